@@ -0,0 +1,113 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Entropy levels, in bits per byte. Text and most documents stay below
+// lowEntropy, while encrypted or compressed data is above highEntropy.
+const (
+	lowEntropy  = 6.0
+	highEntropy = 7.5
+)
+
+// Thresholds are the percentages of the indexed files which can change in
+// a single cycle before it is considered an anomaly. A threshold of 0
+// disables the check.
+type Thresholds struct {
+	// Changed is the percentage of files modified
+	Changed float64
+	// Entropy is the percentage of files whose entropy jumped
+	Entropy float64
+	// Renamed is the percentage of files whose extension changed
+	Renamed float64
+	// MinFiles is the number of files a check needs to trip, so that small
+	// directories don't trip on a couple of changes
+	MinFiles int
+}
+
+// Cycle counts the changes of a backup cycle.
+type Cycle struct {
+	// Files is the number of indexed files before the cycle
+	Files        int
+	Changed      int
+	EntropyJumps int
+	Renamed      int
+}
+
+// Detector detects backup cycles which look like ransomware encrypting
+// files: many modified files, entropy jumps and mass extension changes.
+type Detector struct {
+	thresholds Thresholds
+}
+
+// NewDetector creates a new detector with the thresholds.
+func NewDetector(thresholds Thresholds) (*Detector, error) {
+	for _, threshold := range []float64{thresholds.Changed, thresholds.Entropy, thresholds.Renamed} {
+		if threshold < 0 || threshold > 100 {
+			return nil, fmt.Errorf("invalid threshold %g%%", threshold)
+		}
+	}
+	if thresholds.MinFiles < 0 {
+		return nil, errors.New("minimum number of files cannot be negative")
+	}
+
+	return &Detector{thresholds: thresholds}, nil
+}
+
+// Check returns why the cycle is an anomaly, or nothing if it isn't.
+func (d *Detector) Check(c *Cycle) []string {
+	var reasons []string
+
+	checks := []struct {
+		count     int
+		threshold float64
+		what      string
+	}{
+		{c.Changed, d.thresholds.Changed, "files modified"},
+		{c.EntropyJumps, d.thresholds.Entropy, "files with an entropy jump"},
+		{c.Renamed, d.thresholds.Renamed, "files with a new extension"},
+	}
+
+	for _, check := range checks {
+		if check.threshold == 0 || c.Files == 0 || check.count < d.thresholds.MinFiles {
+			continue
+		}
+		if float64(check.count)*100 > check.threshold*float64(c.Files) {
+			reasons = append(reasons, fmt.Sprintf("%d of %d %s, more than the %g%% threshold", check.count, c.Files, check.what, check.threshold))
+		}
+	}
+
+	return reasons
+}
+
+// Entropy returns the Shannon entropy of the data, in bits per byte.
+func Entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// EntropyJump returns whether a file went from low entropy content to what
+// looks like encrypted content.
+func EntropyJump(before, after float64) bool {
+	return before > 0 && before < lowEntropy && after >= highEntropy
+}
@@ -0,0 +1,80 @@
+package anomaly
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	text := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 1000))
+
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+
+	if e := Entropy(nil); e != 0 {
+		t.Errorf("Expected no entropy for empty data, got %f", e)
+	}
+	if e := Entropy([]byte("aaaa")); e != 0 {
+		t.Errorf("Expected no entropy for a single byte value, got %f", e)
+	}
+	if e := Entropy(text); e >= lowEntropy {
+		t.Errorf("Expected low entropy for text, got %f", e)
+	}
+	if e := Entropy(random); e < highEntropy {
+		t.Errorf("Expected high entropy for random data, got %f", e)
+	}
+
+	if !EntropyJump(Entropy(text), Entropy(random)) {
+		t.Error("Expected an entropy jump from text to random data")
+	}
+	if EntropyJump(Entropy(random), Entropy(random)) {
+		t.Error("Expected no entropy jump between random data")
+	}
+	if EntropyJump(0, Entropy(random)) {
+		t.Error("Expected no entropy jump from an unknown entropy")
+	}
+}
+
+func TestDetector(t *testing.T) {
+	detector, err := NewDetector(Thresholds{Changed: 50, Entropy: 20, Renamed: 20, MinFiles: 10})
+	if err != nil {
+		t.Fatalf("Failed to initialize detector: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cycle   Cycle
+		reasons int
+	}{
+		{"Quiet", Cycle{Files: 1000, Changed: 12}, 0},
+		{"FirstBackup", Cycle{Files: 0, Changed: 0}, 0},
+		{"SmallDirectory", Cycle{Files: 4, Changed: 4, EntropyJumps: 4, Renamed: 4}, 0},
+		{"MassChange", Cycle{Files: 1000, Changed: 600}, 1},
+		{"Ransomware", Cycle{Files: 1000, Changed: 600, EntropyJumps: 500, Renamed: 300}, 3},
+		{"AtThreshold", Cycle{Files: 100, Changed: 50}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := detector.Check(&tt.cycle)
+			if len(reasons) != tt.reasons {
+				t.Errorf("Expected %d reasons, got %v", tt.reasons, reasons)
+			}
+		})
+	}
+
+	disabled, err := NewDetector(Thresholds{})
+	if err != nil {
+		t.Fatalf("Failed to initialize detector: %v", err)
+	}
+	if reasons := disabled.Check(&Cycle{Files: 1000, Changed: 1000, EntropyJumps: 1000, Renamed: 1000}); len(reasons) != 0 {
+		t.Errorf("Expected disabled checks, got %v", reasons)
+	}
+
+	if _, err := NewDetector(Thresholds{Changed: 120}); err == nil {
+		t.Error("Expected an error for a threshold above 100%")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+var checkSample float64
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that every backed up file can be restored, without writing to disk",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if checkSample <= 0 || checkSample > 100 {
+			fmt.Println("Sample must be a percentage between 0 and 100")
+			os.Exit(1)
+		}
+
+		b := openStorage()
+		loadDatabase(b)
+
+		paths := make([]string, 0, len(database))
+		for path := range database {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		// Check a random sample of the files, at least one
+		if checkSample < 100 && len(paths) > 0 {
+			n := int(float64(len(paths)) * checkSample / 100)
+			if n == 0 {
+				n = 1
+			}
+			rand.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+			paths = paths[:n]
+			sort.Strings(paths)
+		}
+
+		// Every stored object must be referenced by the index, unless the
+		// bucket may hold objects of other applications, or newer generations
+		// reference them
+		var objects []storage.ObjectInfo
+		switch {
+		case atBucketRoot():
+			fmt.Println("Repository is stored at the root of the bucket, not looking for orphaned objects")
+		case config.Generation != "":
+			fmt.Printf("Checking generation %s, not looking for orphaned objects\n", config.Generation)
+		default:
+			var err error
+			objects, err = b.List("")
+			if err != nil {
+				fmt.Printf("Failed to list objects: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		result := checkBackup(b, paths, objects)
+		fmt.Printf("Checked %d of %d files and %d objects: %d missing, %d undecryptable, %d corrupt, %d orphaned\n",
+			len(paths), len(database), len(objects), result.missing, result.undecryptable, result.corrupt, result.orphaned)
+
+		// Orphaned objects are left behind by interrupted uploads, they are only reported
+		if result.missing+result.undecryptable+result.corrupt > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// checkResult counts the problems found by check.
+type checkResult struct {
+	missing, undecryptable, corrupt, orphaned int
+}
+
+// checkBackup downloads and verifies the files of the index at paths, and
+// looks for the objects not referenced by the index, printing every problem.
+func checkBackup(b *transform.Pipeline, paths []string, objects []storage.ObjectInfo) checkResult {
+	var result checkResult
+	for _, path := range paths {
+		file := database[path]
+		data, err := b.Retrieve(file.objectKey(path), file.Version)
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Printf("Missing: %s\n", path)
+			result.missing++
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Undecryptable: %s: %v\n", path, err)
+			result.undecryptable++
+			continue
+		}
+
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != file.Sum {
+			fmt.Printf("Corrupt: %s\n", path)
+			result.corrupt++
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for path, file := range database {
+		referenced[file.objectKey(path)] = true
+	}
+	for _, object := range objects {
+		if !referenced[object.Key] && !repositoryObject(object.Key) {
+			fmt.Printf("Orphaned: %s\n", object.Key)
+			result.orphaned++
+		}
+	}
+
+	return result
+}
+
+func init() {
+	checkCmd.Flags().Float64Var(&checkSample, "sample", 100, "Percentage of the files to download and check")
+	rootCmd.AddCommand(checkCmd)
+}
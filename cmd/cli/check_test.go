@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+func TestCheckBackup(t *testing.T) {
+	content := []byte("a.txt")
+	hash := sha256.Sum256(content)
+	sum := hex.EncodeToString(hash[:])
+
+	tests := []struct {
+		name string
+		// Stores the objects of the bucket, once a.txt is stored
+		store  func(b *transform.Pipeline, backend *storagetest.MemoryBackend)
+		file   *File
+		result checkResult
+	}{
+		{"intact", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {}, &File{Sum: sum, Version: 1}, checkResult{}},
+		{"corrupt", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {}, &File{Sum: "0000", Version: 1}, checkResult{corrupt: 1}},
+		// e.g. an older version put back in place of the indexed one
+		{"another version", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {}, &File{Sum: sum, Version: 2}, checkResult{undecryptable: 1}},
+		{"undecryptable", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {
+			backend.Put("a.txt", []byte("garbage"))
+		}, &File{Sum: sum, Version: 1}, checkResult{undecryptable: 1}},
+		{"missing", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {
+			backend.Delete("a.txt")
+		}, &File{Sum: sum, Version: 1}, checkResult{missing: 1}},
+		{"orphaned", func(b *transform.Pipeline, backend *storagetest.MemoryBackend) {
+			storeObject(t, b, "orphan.txt")
+		}, &File{Sum: sum, Version: 1}, checkResult{orphaned: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupCLI(t)
+			backend := storagetest.NewMemoryBackend()
+			b := testPipeline(t, backend, "repo")
+			storeObject(t, b, "a.txt")
+			// Objects of the repository itself are never orphaned
+			backend.Put(repository.HeadKey, []byte("index"))
+			tt.store(b, backend)
+
+			database = map[string]*File{"a.txt": tt.file}
+			objects, err := backend.List("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result := checkBackup(b, []string{"a.txt"}, objects); result != tt.result {
+				t.Errorf("Expected %+v, got %+v", tt.result, result)
+			}
+		})
+	}
+}
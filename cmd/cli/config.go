@@ -19,6 +19,10 @@ type Config struct {
 		StorageClass string `mapstructure:"storage-class"`
 	} `mapstructure:"s3"`
 
+	Repository struct {
+		ID string `mapstructure:"id"`
+	} `mapstructure:"repository"`
+
 	Backup struct {
 		Dir string `mapstructure:"dir"`
 	} `mapstructure:"backup"`
@@ -40,7 +44,37 @@ type Config struct {
 
 		PresharedKey   string `mapstructure:"preshared-key"`
 		PresharedKeyID string `mapstructure:"preshared-key-id"`
+
+		KEM string `mapstructure:"kem"`
 	} `mapstructure:"hpke"`
+
+	Age struct {
+		GenKey           bool   `mapstructure:"gen-key"`
+		IdentityLocation string `mapstructure:"identity-location"`
+		Passphrase       string `mapstructure:"passphrase"`
+	} `mapstructure:"age"`
+
+	OpenPGP struct {
+		PrivateKeyLocation string `mapstructure:"private-key-location"`
+		Passphrase         string `mapstructure:"passphrase"`
+	} `mapstructure:"openpgp"`
+
+	Restore struct {
+		Strict        bool   `mapstructure:"strict"`
+		Report        string `mapstructure:"report"`
+		QuarantineDir string `mapstructure:"quarantine-dir"`
+	} `mapstructure:"restore"`
+
+	Sign struct {
+		GenKey            bool   `mapstructure:"gen-key"`
+		PublicKeyLocation string `mapstructure:"public-key-location"`
+		AllowUnsigned     bool   `mapstructure:"allow-unsigned"`
+	} `mapstructure:"sign"`
+
+	Index       string `mapstructure:"index"`
+	Generation  string `mapstructure:"generation"`
+	AllowLegacy bool   `mapstructure:"allow-legacy"`
+	Host        string `mapstructure:"host"`
 }
 
 var config Config
@@ -51,39 +85,67 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	// S3 Related
-	rootCmd.Flags().String("s3.access-id", "", "S3 access ID")
-	rootCmd.Flags().String("s3.access-key", "", "S3 access key")
-	rootCmd.Flags().String("s3.bucket-name", "", "S3 bucket name")
-	rootCmd.Flags().String("s3.endpoint", "", "S3 endpoint")
-	rootCmd.Flags().String("s3.region", "", "S3 region")
-	rootCmd.Flags().String("s3.dir", "", "S3 directory (will store under a directory in S3)")
-	rootCmd.Flags().String("s3.storage-class", "", "S3 storage class")
+	rootCmd.PersistentFlags().String("s3.access-id", "", "S3 access ID")
+	rootCmd.PersistentFlags().String("s3.access-key", "", "S3 access key")
+	rootCmd.PersistentFlags().String("s3.bucket-name", "", "S3 bucket name")
+	rootCmd.PersistentFlags().String("s3.endpoint", "", "S3 endpoint")
+	rootCmd.PersistentFlags().String("s3.region", "", "S3 region")
+	rootCmd.PersistentFlags().String("s3.dir", "", "S3 directory (will store under a directory in S3)")
+	rootCmd.PersistentFlags().String("s3.storage-class", "", "S3 storage class")
 
 	rootCmd.MarkFlagsRequiredTogether("s3.access-id", "s3.access-key", "s3.bucket-name", "s3.endpoint", "s3.region")
 
+	// Repository Related
+	rootCmd.PersistentFlags().String("repository.id", "", "Repository ID, bound to every stored object (must match between backup and restore), read from the repository if empty")
+	rootCmd.PersistentFlags().String("host", "", "Host to restore from, see the hosts command (defaults to the one recorded in the backup directory, else the hostname)")
+
 	// AES Related
-	rootCmd.Flags().String("aes.key-location", "", "AES key location")
+	rootCmd.PersistentFlags().String("aes.key-location", "", "AES key location")
 
 	// ECIES Related
-	rootCmd.Flags().String("ecies.private-key-location", "", "ECIES private key location")
+	rootCmd.PersistentFlags().String("ecies.private-key-location", "", "ECIES private key location")
 
 	// HPKE Related
-	rootCmd.Flags().String("hpke.client-public-key-location", "", "HPKE client public key location")
-	rootCmd.Flags().String("hpke.server-public-key-location", "", "HPKE server public key location")
-	rootCmd.Flags().String("hpke.server-secret-key-location", "", "HPKE server secret key location")
-	rootCmd.Flags().String("hpke.preshared-key", "", "HPKE preshared key")
-	rootCmd.Flags().String("hpke.preshared-key-id", "", "HPKE preshared key ID")
+	rootCmd.PersistentFlags().String("hpke.client-public-key-location", "", "HPKE client public key location")
+	rootCmd.PersistentFlags().String("hpke.server-public-key-location", "", "HPKE server public key location")
+	rootCmd.PersistentFlags().String("hpke.server-secret-key-location", "", "HPKE server secret key location")
+	rootCmd.PersistentFlags().String("hpke.preshared-key", "", "HPKE preshared key")
+	rootCmd.PersistentFlags().String("hpke.preshared-key-id", "", "HPKE preshared key ID")
+	rootCmd.PersistentFlags().String("hpke.kem", "x25519", "HPKE KEM used to generate key pairs (x25519, x448, p256, p384, p521)")
+
+	// Age Related
+	rootCmd.PersistentFlags().String("age.identity-location", "", "Age identity file location (age secret keys or SSH private key)")
+	rootCmd.PersistentFlags().String("age.passphrase", "", "Age passphrase (scrypt)")
+
+	// OpenPGP Related
+	rootCmd.PersistentFlags().String("openpgp.private-key-location", "", "OpenPGP armored private key location")
+	rootCmd.PersistentFlags().String("openpgp.passphrase", "", "OpenPGP private key passphrase")
+
+	// Signing Related
+	rootCmd.PersistentFlags().String("sign.public-key-location", "", "Ed25519 signing public key location (PEM), unsigned objects are refused")
+	rootCmd.PersistentFlags().Bool("sign.allow-unsigned", false, "Restore unsigned objects, still verifying signed ones")
+
+	// Restore Related
+	rootCmd.Flags().Bool("restore.strict", true, "Only write files matching their hash, quarantining the others")
+	rootCmd.Flags().String("restore.report", "-", "Where to write the JSON restore report, - for stdout")
+	rootCmd.Flags().String("restore.quarantine-dir", "", "Where to write files failing verification (defaults to .gosafe/quarantine in the restore directory)")
 
 	// Encryption related
-	rootCmd.MarkFlagsMutuallyExclusive("aes.key-location", "ecies.private-key-location", "hpke.server-secret-key-location")
+	rootCmd.MarkFlagsMutuallyExclusive("aes.key-location", "ecies.private-key-location", "hpke.server-secret-key-location", "age.identity-location", "age.passphrase", "openpgp.private-key-location")
 
 	// Misc
-	rootCmd.Flags().String("backup.dir", "", "Backup directory (where to save to)")
-	rootCmd.Flags().Bool("ecies.gen-key", false, "Generate ECIES key pair")
-	rootCmd.Flags().Bool("hpke.gen-key", false, "Generate a client and a server HPKE key pair")
+	rootCmd.PersistentFlags().String("backup.dir", "", "Backup directory (where to save to)")
+	rootCmd.PersistentFlags().Bool("allow-legacy", false, "Restore the objects stored before they were bound to their key, repository and version")
+	rootCmd.PersistentFlags().String("index", "", "Local index file to use instead of the one in S3, e.g. from recover-index")
+	rootCmd.PersistentFlags().String("generation", "", "Generation of the index to read instead of the newest one, see the generations command")
+	rootCmd.MarkFlagsMutuallyExclusive("index", "generation")
+	rootCmd.PersistentFlags().Bool("ecies.gen-key", false, "Generate ECIES key pair")
+	rootCmd.PersistentFlags().Bool("hpke.gen-key", false, "Generate a client and a server HPKE key pair")
+	rootCmd.PersistentFlags().Bool("age.gen-key", false, "Generate an age X25519 identity")
+	rootCmd.PersistentFlags().Bool("sign.gen-key", false, "Generate an Ed25519 signing key pair")
 
 	// Bind flags to environment variables
-	viper.BindPFlags(rootCmd.Flags())
+	viper.BindPFlags(rootCmd.PersistentFlags())
 
 	viper.AutomaticEnv() // Read environment variables
 
@@ -103,11 +165,19 @@ func initConfig() {
 	viper.AddConfigPath(".")             // Path to look for the config file in
 	viper.AddConfigPath("$HOME")         // Path to look for the config file in
 
+	viper.BindPFlags(rootCmd.PersistentFlags())
 	viper.BindPFlags(rootCmd.Flags())
 	viper.SetDefault("backup.dir", "/backup")
 	viper.SetDefault("s3.storage-class", "STANDARD")
 	viper.SetDefault("ecies.gen-key", false)
 	viper.SetDefault("hpke.gen-key", false)
+	viper.SetDefault("hpke.kem", "x25519")
+	viper.SetDefault("age.gen-key", false)
+	viper.SetDefault("sign.gen-key", false)
+	viper.SetDefault("sign.allow-unsigned", false)
+	viper.SetDefault("allow-legacy", false)
+	viper.SetDefault("restore.strict", true)
+	viper.SetDefault("restore.report", "-")
 
 	viper.AutomaticEnv()
 
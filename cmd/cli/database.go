@@ -1,7 +1,189 @@
 package main
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yyewolf/go-safe/lock"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
 type File struct {
-	Sum string `json:"s"`
+	Sum     string `json:"s"`
+	Version uint64 `json:"v,omitempty"`
+	// Key is the key of the object, when it isn't the path of the file
+	Key string `json:"k,omitempty"`
+	// Size, ModTime (in Unix seconds) and Mode of the file when it was backed up
+	Size    int64       `json:"z,omitempty"`
+	ModTime int64       `json:"m,omitempty"`
+	Mode    os.FileMode `json:"p,omitempty"`
+}
+
+// objectKey returns the key of the object storing the file at path.
+func (f *File) objectKey(path string) string {
+	if f.Key != "" {
+		return f.Key
+	}
+	return path
 }
 
 var database map[string]*File
+
+// databaseKey and manifestKey are the keys of the loaded index and its
+// manifest, and databaseVersion the version they are bound to.
+var databaseKey, manifestKey string
+var databaseVersion uint64
+
+// loadDatabase downloads and decodes the latest index, or reads the local one
+// given with --index, exiting on failure. If the latest generation of the
+// index can't be downloaded, decrypted or parsed, the previous ones are tried.
+func loadDatabase(b *transform.Pipeline) {
+	databaseKey, manifestKey = "db.gosafe", "manifest.gosafe"
+	databaseVersion = 0
+
+	if config.Index != "" {
+		data, err := os.ReadFile(config.Index)
+		if err != nil {
+			fmt.Printf("Failed to read index: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = json.Unmarshal(data, &database)
+		if err != nil {
+			fmt.Printf("Failed to unmarshal %s: %v\n", config.Index, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// A generation asked for with --generation is never fallen back from
+	if config.Generation != "" {
+		key := generationKey(config.Generation)
+		index, version, err := readGeneration(b, key)
+		if err != nil {
+			fmt.Printf("Failed to load generation %s: %v\n", config.Generation, err)
+			os.Exit(1)
+		}
+		useGeneration(index, key, version)
+		return
+	}
+
+	for i, generation := range indexGenerations(b) {
+		key := generation.Key
+		index, version, err := readGeneration(b, key)
+		if errors.Is(err, storage.ErrNotFound) && key == "db.gosafe" && i > 0 {
+			// Only repositories from before index generations have one
+			break
+		}
+		if err != nil {
+			fmt.Printf("Failed to load %s: %v\n", key, err)
+			continue
+		}
+
+		if i > 0 {
+			fmt.Printf("Warning: falling back to %s\n", key)
+		}
+		useGeneration(index, key, version)
+		checkHead(b, key, version)
+		return
+	}
+
+	if namespace != "" {
+		fmt.Printf("Failed to load any generation of the index of host %s, see the hosts command\n", host)
+		os.Exit(1)
+	}
+	fmt.Println("Failed to load any generation of the index")
+	os.Exit(1)
+}
+
+// readGeneration downloads and decodes a generation of the index, returning it
+// along with the version it is bound to.
+func readGeneration(b *transform.Pipeline, key string) (map[string]*File, uint64, error) {
+	data, version, err := b.RetrieveVersion(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := make(map[string]*File)
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return index, version, nil
+}
+
+// useGeneration makes the generation of the index the loaded one.
+func useGeneration(index map[string]*File, key string, version uint64) {
+	database = index
+	databaseKey = key
+	databaseVersion = version
+	if strings.HasPrefix(key, repository.IndexPrefix) {
+		manifestKey = repository.ManifestKey(key)
+	}
+}
+
+// indexGenerations returns the generations of the index to try, newest first.
+// A generation is only stored once its manifest is, so every listed generation
+// is complete. db.gosafe, where the index was stored before generations, is
+// tried last.
+func indexGenerations(b *transform.Pipeline) []storage.ObjectInfo {
+	generations, err := b.List(repository.IndexPrefix)
+	if err != nil {
+		fmt.Printf("Failed to list index generations: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Generation keys sort chronologically
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Key > generations[j].Key
+	})
+
+	return append(generations, storage.ObjectInfo{Key: "db.gosafe"})
+}
+
+// generationName returns the name of a generation of the index, as given to
+// --generation: its time, or db.gosafe for the index stored before generations.
+func generationName(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, repository.IndexPrefix), ".gosafe")
+}
+
+// generationKey returns the key of the generation of the index given to
+// --generation, either by name or by key.
+func generationKey(name string) string {
+	if name == "db" || name == "db.gosafe" {
+		return "db.gosafe"
+	}
+	return repository.IndexPrefix + generationName(name) + ".gosafe"
+}
+
+// checkHead warns if head.gosafe doesn't point to the loaded generation of the
+// index: either the last upload was interrupted before moving it, or an older
+// head.gosafe was put back to roll the backup back.
+func checkHead(b *transform.Pipeline, key string, version uint64) {
+	// There is no head in append-only mode, it can't be overwritten
+	head, headVersion, err := b.RetrieveVersion(repository.HeadKey)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		fmt.Printf("Warning: failed to read head.gosafe: %v\n", err)
+	case headVersion > version:
+		fmt.Printf("Warning: head.gosafe points to %s (version %d), newer than %s (version %d), newer generations are missing or unreadable\n",
+			head, headVersion, key, version)
+	case string(head) != key:
+		fmt.Printf("Warning: head.gosafe points to %s (version %d), but %s (version %d) is newer, the last upload was interrupted or head.gosafe was rolled back\n",
+			head, headVersion, key, version)
+	}
+}
+
+// repositoryObject returns whether the object belongs to the repository itself
+// (config, indexes, manifests, head and locks) rather than storing a file.
+func repositoryObject(key string) bool {
+	return repository.Reserved(key) || strings.HasPrefix(key, lock.Prefix)
+}
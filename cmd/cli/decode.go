@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+var decodeOutput string
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode <object> <key>",
+	Short: "Decode an object downloaded from the bucket, given the key it was stored under",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Everything else printed goes to stderr, so that the content written
+		// to stdout can be piped as is
+		output := os.Stdout
+		if decodeOutput == "-" {
+			os.Stdout = os.Stderr
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("Failed to read object: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The key may be given as listed in the bucket
+		key := strings.TrimPrefix(args[1], strings.Trim(config.S3.Dir, "/")+"/")
+
+		var b *transform.Pipeline
+		if config.S3.AccessID != "" {
+			b = openStorage()
+			key = strings.TrimPrefix(key, namespace)
+		} else {
+			b, key = offlinePipeline(key)
+		}
+
+		content, metadata, version, err := decodeObject(b, key, data)
+		if err != nil {
+			fmt.Printf("Failed to decode %s: %v\n", key, err)
+			os.Exit(1)
+		}
+
+		if decodeOutput == "-" {
+			output.Write(content)
+			return
+		}
+
+		err = os.WriteFile(decodeOutput, content, 0600)
+		if err != nil {
+			fmt.Printf("Failed to write %s: %v\n", decodeOutput, err)
+			os.Exit(1)
+		}
+
+		path := key
+		if metadata != nil {
+			path = metadata.Path
+		}
+		fmt.Printf("Decoded %s (version %d) into %s\n", path, version, decodeOutput)
+	},
+}
+
+// offlinePipeline returns a pipeline decoding objects without S3 nor the
+// repository config, and the key of the object in the namespace of its host.
+// The repository ID is --repository.id, and the host is read from the key,
+// else --host, for repositories with namespaces.
+func offlinePipeline(key string) (*transform.Pipeline, string) {
+	encryptionBackend := encryptionBackend()
+	if encryptionBackend == nil {
+		fmt.Println("No encryption backend configured")
+		os.Exit(1)
+	}
+
+	repo := &repository.Config{ID: config.Repository.ID}
+	host := config.Host
+	if rest, ok := strings.CutPrefix(key, repository.HostsPrefix); ok {
+		if name, rest, ok := strings.Cut(rest, "/"); ok && (host == "" || name == host) {
+			host, key = name, rest
+		}
+	}
+	if host != "" {
+		repo.Namespaces = true
+	}
+	repositoryID = repo.BoundID(host)
+
+	return pipeline(offlineBackend{}, encryptionBackend), key
+}
+
+// offlineBackend is the storage backend of a pipeline which only decodes
+// objects read from local files.
+type offlineBackend struct{}
+
+var errOffline = errors.New("no storage backend configured")
+
+func (offlineBackend) Initialize(config storage.Config) error {
+	return nil
+}
+
+func (offlineBackend) Store(key string, version uint64, data []byte) error {
+	return errOffline
+}
+
+func (offlineBackend) Retrieve(key string, version uint64) ([]byte, error) {
+	return nil, errOffline
+}
+
+func (offlineBackend) List(prefix string) ([]storage.ObjectInfo, error) {
+	return nil, errOffline
+}
+
+func (offlineBackend) Delete(key string) error {
+	return errOffline
+}
+
+func init() {
+	decodeCmd.Flags().StringVar(&decodeOutput, "output", "-", "Where to write the content of the file, - for stdout")
+	rootCmd.AddCommand(decodeCmd)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+func TestOfflinePipeline(t *testing.T) {
+	setupCLI(t)
+	config.Repository.ID = "repo"
+
+	// Stored by the daemon of web-1
+	repo := &repository.Config{ID: "repo", Namespaces: true}
+	stored := storagetest.NewMemoryBackend()
+	b := testPipeline(t, stored, repo.BoundID("web-1"))
+	content := []byte("root:x:0:0:root:/root:/bin/sh\n")
+	if err := b.StoreFile("etc/passwd", 3, content, &transform.Metadata{Path: "etc/passwd"}); err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	object := stored.Get("etc/passwd")
+
+	tests := []struct {
+		name, key, host string
+		decoded         bool
+	}{
+		{"key as listed in the bucket", "hosts/web-1/etc/passwd", "", true},
+		{"key in the namespace", "etc/passwd", "web-1", true},
+		{"another host", "hosts/web-2/etc/passwd", "", false},
+		{"without namespace", "etc/passwd", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Host = tt.host
+			p, key := offlinePipeline(tt.key)
+			decoded, metadata, version, err := decodeObject(p, key, object)
+			if !tt.decoded {
+				if err == nil {
+					t.Error("Expected the object not to decode")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to decode object: %v", err)
+			}
+			if !bytes.Equal(decoded, content) || metadata.Path != "etc/passwd" || version != 3 {
+				t.Errorf("Unexpected object: %q, %+v, version %d", decoded, metadata, version)
+			}
+		})
+	}
+}
+
+// setupCLI configures an AES key and resets the configuration and the state of
+// the repository once the test is over.
+func setupCLI(t *testing.T) {
+	savedConfig, savedDatabase := config, database
+	savedHost, savedNamespace, savedID := host, namespace, repositoryID
+	t.Cleanup(func() {
+		config, database = savedConfig, savedDatabase
+		host, namespace, repositoryID = savedHost, savedNamespace, savedID
+	})
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "aes.key")
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config = Config{}
+	config.AES.KeyLocation = keyFile
+	host, namespace, repositoryID = "", "", ""
+}
+
+// testPipeline returns the pipeline of the retriever over the backend, bound
+// to the repository ID.
+func testPipeline(t *testing.T, backend *storagetest.MemoryBackend, id string) *transform.Pipeline {
+	saved := repositoryID
+	defer func() {
+		repositoryID = saved
+	}()
+
+	repositoryID = id
+	return pipeline(backend, encryptionBackend())
+}
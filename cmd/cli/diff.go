@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/repository"
+)
+
+var diffJSON bool
+
+// DiffReport lists the differences between a local directory and the index.
+type DiffReport struct {
+	// Added files are only in the local directory
+	Added []string `json:"added"`
+	// Modified files have a different content
+	Modified []string `json:"modified"`
+	// Deleted files are only in the index
+	Deleted []string `json:"deleted"`
+	// MetadataChanged files have the same content, but a different size,
+	// modification time or mode
+	MetadataChanged []string `json:"metadata_changed"`
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [dir]",
+	Short: "Compare a local directory (the backup directory by default) against the remote index, or one of its generations with --generation",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := config.Backup.Dir
+		if len(args) == 1 {
+			dir = args[0]
+		}
+
+		// Check that the directory exists and is a directory
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			fmt.Println("Directory does not exist or is not a directory")
+			os.Exit(1)
+		}
+
+		// Nothing is ever uploaded, only the index is downloaded
+		b := openStorage()
+		loadDatabase(b)
+
+		report, err := diffDirectory(dir)
+		if err != nil {
+			fmt.Printf("Failed to walk %s: %v\n", dir, err)
+			os.Exit(1)
+		}
+
+		if diffJSON {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Printf("Failed to marshal diff: %v\n", err)
+				os.Exit(1)
+			}
+			os.Stdout.Write(append(data, '\n'))
+		} else {
+			printDiff("A", report.Added)
+			printDiff("M", report.Modified)
+			printDiff("D", report.Deleted)
+			printDiff("m", report.MetadataChanged)
+		}
+
+		// Like diff(1), differences are reported with exit status 1
+		if len(report.Added)+len(report.Modified)+len(report.Deleted)+len(report.MetadataChanged) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// diffDirectory compares the directory against the loaded index.
+func diffDirectory(dir string) (*DiffReport, error) {
+	report := &DiffReport{
+		Added:           []string{},
+		Modified:        []string{},
+		Deleted:         []string{},
+		MetadataChanged: []string{},
+	}
+
+	seen := make(map[string]bool)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip what the daemon skips
+		if !info.Mode().IsRegular() || info.Mode()&0400 == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "alert.gosafe" || rel == "host.gosafe" || rel == "repository.gosafe" ||
+			repository.Reserved(filepath.ToSlash(rel)) || repository.Temporary(filepath.ToSlash(rel)) {
+			return nil
+		}
+
+		file, ok := database[rel]
+		if !ok {
+			report.Added = append(report.Added, rel)
+			return nil
+		}
+		seen[rel] = true
+
+		sum, err := fileSum(path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case sum != file.Sum:
+			report.Modified = append(report.Modified, rel)
+		case file.Size != info.Size() ||
+			(file.ModTime != 0 && file.ModTime != info.ModTime().Unix()) ||
+			(file.Mode != 0 && file.Mode != info.Mode().Perm()):
+			report.MetadataChanged = append(report.MetadataChanged, rel)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for path := range database {
+		if !seen[path] {
+			report.Deleted = append(report.Deleted, path)
+		}
+	}
+	sort.Strings(report.Deleted)
+
+	return report, nil
+}
+
+func printDiff(status string, paths []string) {
+	for _, path := range paths {
+		fmt.Println(status, path)
+	}
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Output as JSON")
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffDirectory(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(dir string)
+		report DiffReport
+	}{
+		{"unchanged", func(dir string) {}, DiffReport{}},
+		{"added", func(dir string) {
+			writeDiffFile(t, dir, "b/new.txt", "new")
+		}, DiffReport{Added: []string{filepath.Join("b", "new.txt")}}},
+		{"modified", func(dir string) {
+			writeDiffFile(t, dir, "a.txt", "changed")
+		}, DiffReport{Modified: []string{"a.txt"}}},
+		{"deleted", func(dir string) {
+			os.Remove(filepath.Join(dir, "b", "c.txt"))
+		}, DiffReport{Deleted: []string{filepath.Join("b", "c.txt")}}},
+		{"mode changed", func(dir string) {
+			os.Chmod(filepath.Join(dir, "a.txt"), 0644)
+		}, DiffReport{MetadataChanged: []string{"a.txt"}}},
+		{"modification time changed", func(dir string) {
+			modTime := time.Now().Add(time.Hour)
+			os.Chtimes(filepath.Join(dir, "a.txt"), modTime, modTime)
+		}, DiffReport{MetadataChanged: []string{"a.txt"}}},
+		// What the daemon never backs up isn't added
+		{"skipped files", func(dir string) {
+			writeDiffFile(t, dir, ".gosafe/quarantine/a.txt", "corrupt")
+			writeDiffFile(t, dir, "b/.gosafe-123456", "partial")
+			writeDiffFile(t, dir, "host.gosafe", "web-1")
+		}, DiffReport{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupCLI(t)
+			dir := t.TempDir()
+			writeDiffFile(t, dir, "a.txt", "a")
+			writeDiffFile(t, dir, "b/c.txt", "c")
+
+			// The index of the directory as backed up
+			database = make(map[string]*File)
+			for _, rel := range []string{"a.txt", filepath.Join("b", "c.txt")} {
+				path := filepath.Join(dir, rel)
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum, err := fileSum(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				database[rel] = &File{Sum: sum, Size: info.Size(), ModTime: info.ModTime().Unix(), Mode: info.Mode().Perm()}
+			}
+
+			tt.change(dir)
+
+			report, err := diffDirectory(dir)
+			if err != nil {
+				t.Fatalf("Failed to diff: %v", err)
+			}
+			// Every list of the report is empty rather than nil
+			expected := tt.report
+			for _, list := range []*[]string{&expected.Added, &expected.Modified, &expected.Deleted, &expected.MetadataChanged} {
+				if *list == nil {
+					*list = []string{}
+				}
+			}
+			if !reflect.DeepEqual(*report, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, *report)
+			}
+		})
+	}
+}
+
+// writeDiffFile writes a file readable by its owner only, like the daemon's.
+func writeDiffFile(t *testing.T, dir, path, content string) {
+	path = filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile doesn't change the mode of existing files
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
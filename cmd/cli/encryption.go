@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/yyewolf/go-safe/encryption"
 )
@@ -16,13 +17,40 @@ func encryptionBackend() encryption.EncryptionBackend {
 		return eciesPrivateEncryptionBackend()
 	}
 
-	if config.HPKE.ClientPublicKeyLocation != "" && config.HPKE.ServerSecretKeyLocation != "" && config.HPKE.ServerPublicKeyLocation != "" {
+	if config.HPKE.ServerSecretKeyLocation != "" {
 		return hpkeEncryptionBackend()
 	}
 
+	if config.Age.IdentityLocation != "" || config.Age.Passphrase != "" {
+		return ageEncryptionBackend()
+	}
+
+	if config.OpenPGP.PrivateKeyLocation != "" {
+		return openPGPEncryptionBackend()
+	}
+
 	return nil
 }
 
+// encryptionName returns the name of the configured encryption backend, as
+// recorded in the repository config, or an empty string if there is none.
+func encryptionName() string {
+	switch {
+	case config.AES.KeyLocation != "":
+		return "aes"
+	case config.ECIES.PrivateKeyLocation != "":
+		return "ecies"
+	case config.HPKE.ServerSecretKeyLocation != "":
+		return "hpke"
+	case config.Age.IdentityLocation != "" || config.Age.Passphrase != "":
+		return "age"
+	case config.OpenPGP.PrivateKeyLocation != "":
+		return "openpgp"
+	}
+
+	return ""
+}
+
 func aesEncryptionBackend() encryption.EncryptionBackend {
 	// Check key file permissions and existence
 	st, err := os.Stat(config.AES.KeyLocation)
@@ -86,57 +114,103 @@ func eciesPrivateEncryptionBackend() encryption.EncryptionBackend {
 }
 
 func hpkeEncryptionBackend() encryption.EncryptionBackend {
+	// Read the key files, the client public key is only needed in auth modes
+	serverSecretKey := readHPKEKey(config.HPKE.ServerSecretKeyLocation, "server secret key")
+	serverPublicKey := readHPKEKey(config.HPKE.ServerPublicKeyLocation, "server public key")
+	clientPublicKey := readHPKEKey(config.HPKE.ClientPublicKeyLocation, "client public key")
+
+	// Configure encryption backend, the suite is read from each ciphertext
+	encryptionBackend, err := encryption.NewHPKEBackend(clientPublicKey, nil, serverPublicKey, serverSecretKey, []byte(config.HPKE.PresharedKey), []byte(config.HPKE.PresharedKeyID))
+	if err != nil {
+		fmt.Printf("Failed to configure encryption backend: %v\n", err)
+		os.Exit(1)
+	}
+
+	return encryptionBackend
+}
+
+func readHPKEKey(location string, name string) []byte {
+	if location == "" {
+		return nil
+	}
+
 	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ClientPublicKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+	if st, err := os.Stat(location); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
 		if err != nil {
-			fmt.Printf("Failed to stat client public key file: %v\n", err)
+			fmt.Printf("Failed to stat %s file: %v\n", name, err)
 			os.Exit(1)
 		}
-		fmt.Println("Client public key file permissions are too open")
+		fmt.Printf("%s file permissions are too open\n", strings.ToUpper(name[:1])+name[1:])
 		os.Exit(1)
 	}
 
-	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ServerSecretKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+	// Read the key file
+	key, err := os.ReadFile(location)
+	if err != nil {
+		fmt.Printf("Failed to read %s file: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	return key
+}
+
+func ageEncryptionBackend() encryption.EncryptionBackend {
+	var identities []byte
+	if config.Age.IdentityLocation != "" {
+		// Check key file permissions and existence
+		st, err := os.Stat(config.Age.IdentityLocation)
 		if err != nil {
-			fmt.Printf("Failed to stat client secret key file: %v\n", err)
+			fmt.Printf("Failed to stat identity file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Client secret key file permissions are too open")
-		os.Exit(1)
-	}
 
-	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ServerPublicKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+		// Key should only be readable by the owner
+		if st.Mode() != 0600 && st.Mode() != 0400 {
+			fmt.Println("Identity file permissions are too open")
+			os.Exit(1)
+		}
+
+		// Read the identity file
+		identities, err = os.ReadFile(config.Age.IdentityLocation)
 		if err != nil {
-			fmt.Printf("Failed to stat server public key file: %v\n", err)
+			fmt.Printf("Failed to read identity file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Server public key file permissions are too open")
-		os.Exit(1)
 	}
 
-	// Read the key files
-	clientPublicKey, err := os.ReadFile(config.HPKE.ClientPublicKeyLocation)
+	// Configure encryption backend
+	encryptionBackend, err := encryption.NewAgeEncryptionBackend("", string(identities), config.Age.Passphrase)
 	if err != nil {
-		fmt.Printf("Failed to read client public key file: %v\n", err)
+		fmt.Printf("Failed to configure encryption backend: %v\n", err)
 		os.Exit(1)
 	}
 
-	serverSecretKey, err := os.ReadFile(config.HPKE.ServerSecretKeyLocation)
+	return encryptionBackend
+}
+
+func openPGPEncryptionBackend() encryption.EncryptionBackend {
+	// Check key file permissions and existence
+	st, err := os.Stat(config.OpenPGP.PrivateKeyLocation)
 	if err != nil {
-		fmt.Printf("Failed to read client secret key file: %v\n", err)
+		fmt.Printf("Failed to stat private key file: %v\n", err)
 		os.Exit(1)
 	}
 
-	serverPublicKey, err := os.ReadFile(config.HPKE.ServerPublicKeyLocation)
+	// Key should only be readable by the owner
+	if st.Mode() != 0600 && st.Mode() != 0400 {
+		fmt.Println("Private key file permissions are too open")
+		os.Exit(1)
+	}
+
+	// Read the key file
+	privateKey, err := os.ReadFile(config.OpenPGP.PrivateKeyLocation)
 	if err != nil {
-		fmt.Printf("Failed to read server public key file: %v\n", err)
+		fmt.Printf("Failed to read private key file: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Configure encryption backend
-	encryptionBackend, err := encryption.NewHPKEBackend(clientPublicKey, nil, serverPublicKey, serverSecretKey, []byte(config.HPKE.PresharedKey), []byte(config.HPKE.PresharedKeyID))
+	encryptionBackend, err := encryption.NewOpenPGPEncryptionBackend(nil, string(privateKey), []byte(config.OpenPGP.Passphrase))
 	if err != nil {
 		fmt.Printf("Failed to configure encryption backend: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pathFilter selects files of the index by path or glob. Patterns match
+// slash separated paths, "*" matches within a path segment and "**" matches
+// any number of segments. A pattern naming a directory matches everything in it.
+type pathFilter struct {
+	// paths are the positional arguments, a file must match one of them
+	paths []string
+	// include are patterns a file must match one of
+	include []string
+	// exclude are patterns a file must not match any of
+	exclude []string
+}
+
+// match reports whether the file at p is selected by the filter.
+func (f *pathFilter) match(p string) bool {
+	p = filepath.ToSlash(p)
+
+	if len(f.paths) > 0 && !matchAny(f.paths, p) {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, p) {
+		return false
+	}
+	return !matchAny(f.exclude, p)
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" || matchGlob(pattern, p) || matchGlob(pattern+"/**", p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path against a pattern, segment by segment.
+func matchGlob(pattern string, p string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of segments, including none
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"etc/passwd", "etc/passwd", true},
+		{"etc/passwd", "etc/shadow", false},
+		{"etc/*", "etc/passwd", true},
+		// "*" doesn't cross segments
+		{"etc/*", "etc/ssh/sshd_config", false},
+		{"*.log", "app.log", true},
+		{"*.log", "var/app.log", false},
+		{"etc/**", "etc/ssh/sshd_config", true},
+		// "**" matches no segment at all
+		{"**/*.log", "app.log", true},
+		{"**/*.log", "var/log/app.log", true},
+		{"var/**/app.log", "var/app.log", true},
+		{"var/**/app.log", "var/log/nginx/app.log", true},
+		{"var/**/app.log", "srv/log/app.log", false},
+		{"**", "anything/at/all", true},
+		// Patterns match whole paths
+		{"etc", "etc/passwd", false},
+		{"etc/passwd", "etc", false},
+		{"photos/202?/*.jpg", "photos/2024/beach.jpg", true},
+		{"photos/[0-9]*/*", "photos/holidays/beach.jpg", false},
+		// Malformed patterns match nothing
+		{"etc/[", "etc/[", false},
+	}
+
+	for _, tt := range tests {
+		if match := matchGlob(tt.pattern, tt.path); match != tt.match {
+			t.Errorf("matchGlob(%q, %q) = %v, expected %v", tt.pattern, tt.path, match, tt.match)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	filter := &pathFilter{
+		paths:   []string{"etc/"},
+		include: []string{"**/*.conf", "etc/passwd"},
+		exclude: []string{"etc/secret"},
+	}
+
+	tests := []struct {
+		path  string
+		match bool
+	}{
+		// A pattern naming a directory matches everything in it
+		{"etc/passwd", true},
+		{"etc/nginx/nginx.conf", true},
+		{"etc/hosts", false},
+		{"srv/app.conf", false},
+		{"etc/secret/db.conf", false},
+	}
+
+	for _, tt := range tests {
+		if match := filter.match(tt.path); match != tt.match {
+			t.Errorf("match(%q) = %v, expected %v", tt.path, match, tt.match)
+		}
+	}
+}
+
+func TestRestoreArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"--", "etc/**"}, true},
+		{[]string{"--", "etc/passwd", "etc/shadow"}, true},
+		// e.g. a mistyped check
+		{[]string{"chek"}, false},
+		{[]string{"etc/**", "--", "etc/passwd"}, false},
+	}
+
+	for _, tt := range tests {
+		cmd := &cobra.Command{Use: "go-safe", Args: restoreArgs}
+		if err := cmd.Flags().Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+
+		err := cmd.ValidateArgs(cmd.Flags().Args())
+		if tt.valid != (err == nil) {
+			t.Errorf("Expected %q to be valid: %v, got %v", tt.args, tt.valid, err)
+		}
+	}
+}
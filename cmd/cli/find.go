@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var findCmd = &cobra.Command{
+	Use:   "find <pattern>",
+	Short: "Find files of the backup by glob, only downloading the index",
+	Long:  "Find files of the backup by glob. Patterns without a slash match file names, like find -name, others match whole paths.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b := openStorage()
+		loadDatabase(b)
+
+		entries := findEntries(args[0])
+		if len(entries) == 0 {
+			fmt.Printf("No file matches %s\n", args[0])
+			os.Exit(1)
+		}
+
+		printEntries(entries)
+	},
+}
+
+// findEntries returns the entries of the files of the index matching the
+// pattern, by name if it has no slash, else by path.
+func findEntries(pattern string) []*Entry {
+	filter := &pathFilter{paths: []string{pattern}}
+
+	var entries []*Entry
+	for _, p := range indexPaths() {
+		if strings.Contains(pattern, "/") {
+			if !filter.match(p) {
+				continue
+			}
+		} else if ok, err := path.Match(pattern, path.Base(filepath.ToSlash(p))); err != nil || !ok {
+			continue
+		}
+		entries = append(entries, fileEntry(p))
+	}
+	return entries
+}
+
+func init() {
+	findCmd.Flags().BoolVarP(&listLong, "long", "l", false, "Long listing with size, modification time and hash")
+	findCmd.Flags().BoolVar(&listJSON, "json", false, "Output as JSON")
+	rootCmd.AddCommand(findCmd)
+}
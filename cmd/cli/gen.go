@@ -3,9 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
-	hpke "github.com/jedisct1/go-hpke-compact"
+	"filippo.io/age"
 	ecies "github.com/yyewolf/go-ecies/v2"
+	"github.com/yyewolf/go-safe/encryption"
+	"github.com/yyewolf/go-safe/transform"
 )
 
 func eciesGenKey() {
@@ -26,31 +29,67 @@ func eciesGenKey() {
 }
 
 func hpkeGenKey() {
-	fmt.Println("Generating ECIES keypair...")
-	suite, err := hpke.NewSuite(hpke.KemX25519HkdfSha256, hpke.KdfHkdfSha256, hpke.AeadChaCha20Poly1305)
+	fmt.Println("Generating HPKE keypairs...")
+	kemID, _, _, err := encryption.ParseHPKESuite(config.HPKE.KEM, "", "")
 	if err != nil {
 		panic(err)
 	}
-	clientKp, err := suite.GenerateKeyPair()
+	clientPublicKey, clientSecretKey, err := encryption.GenerateHPKEKeyPair(kemID)
 	if err != nil {
 		panic(err)
 	}
-	serverKp, err := suite.GenerateKeyPair()
+	serverPublicKey, serverSecretKey, err := encryption.GenerateHPKEKeyPair(kemID)
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Storing HPKE keypair into client-priv-key.pem, client-pub-key.pem, server-priv-key.pem, server-pub-key.pem...")
-	if err := os.WriteFile("client-priv-key.pem", clientKp.SecretKey, 0644); err != nil {
+	if err := os.WriteFile("client-priv-key.pem", clientSecretKey, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("client-pub-key.pem", clientPublicKey, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("server-priv-key.pem", serverSecretKey, 0644); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("server-pub-key.pem", serverPublicKey, 0644); err != nil {
+		panic(err)
+	}
+}
+
+func ageGenKey() {
+	fmt.Println("Generating age identity...")
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		panic(err)
+	}
+	recipient := identity.Recipient()
+
+	// Same layout as age-keygen, so the file works with the stock age tool
+	key := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity)
+
+	fmt.Println("Storing age identity into age-key.txt and recipient into age-recipients.txt...")
+	if err := os.WriteFile("age-key.txt", []byte(key), 0600); err != nil {
 		panic(err)
 	}
-	if err := os.WriteFile("client-pub-key.pem", clientKp.PublicKey, 0644); err != nil {
+	if err := os.WriteFile("age-recipients.txt", []byte(recipient.String()+"\n"), 0600); err != nil {
 		panic(err)
 	}
-	if err := os.WriteFile("server-priv-key.pem", serverKp.SecretKey, 0644); err != nil {
+}
+
+func signGenKey() {
+	fmt.Println("Generating Ed25519 signing keypair...")
+	publicKey, privateKey, err := transform.GenerateSigningKeyPair()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Storing signing keypair into sign-priv-key.pem and sign-pub-key.pem...")
+	if err := os.WriteFile("sign-priv-key.pem", privateKey, 0600); err != nil {
 		panic(err)
 	}
-	if err := os.WriteFile("server-pub-key.pem", serverKp.PublicKey, 0644); err != nil {
+	if err := os.WriteFile("sign-pub-key.pem", publicKey, 0644); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+var generationsJSON bool
+
+// Generation describes a generation of the index, as listed.
+type Generation struct {
+	// Name is given to --generation to read this generation
+	Name    string `json:"name"`
+	Created string `json:"created,omitempty"`
+	Version uint64 `json:"version"`
+	Files   int    `json:"files"`
+	Size    int64  `json:"size"`
+	// File is the entry of the file asked for in this generation
+	File *Entry `json:"file,omitempty"`
+}
+
+var generationsCmd = &cobra.Command{
+	Use:   "generations [path]",
+	Short: "List the generations of the index, or the ones containing a file, to read with --generation",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b := openStorage()
+
+		path := ""
+		if len(args) == 1 {
+			path = strings.Trim(filepath.ToSlash(args[0]), "/")
+		}
+
+		generations := listGenerations(b, path)
+		if len(generations) == 0 {
+			if path != "" {
+				fmt.Printf("No generation of the index contains %s\n", path)
+			} else {
+				fmt.Println("No generation of the index found")
+			}
+			os.Exit(1)
+		}
+
+		printGenerations(generations, path != "")
+	},
+}
+
+// listGenerations returns the generations of the index, newest first, only
+// keeping the ones containing the file at path if it isn't empty.
+// Generations which can't be loaded are reported and skipped.
+func listGenerations(b *transform.Pipeline, path string) []*Generation {
+	var generations []*Generation
+	for _, object := range indexGenerations(b) {
+		index, version, err := readGeneration(b, object.Key)
+		if errors.Is(err, storage.ErrNotFound) && object.Key == "db.gosafe" {
+			// Only repositories from before index generations have one
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Failed to load %s: %v\n", object.Key, err)
+			continue
+		}
+
+		generation := &Generation{
+			Name:    generationName(object.Key),
+			Version: version,
+			Files:   len(index),
+		}
+		if !object.LastModified.IsZero() {
+			generation.Created = object.LastModified.UTC().Format(time.RFC3339)
+		}
+		for p, file := range index {
+			generation.Size += file.Size
+			if path != "" && filepath.ToSlash(p) == path {
+				generation.File = indexEntry(p, file)
+			}
+		}
+
+		if path != "" && generation.File == nil {
+			continue
+		}
+		generations = append(generations, generation)
+	}
+	return generations
+}
+
+// printGenerations prints the generations, with the entry of their file rather
+// than their size when listing the generations containing a file.
+func printGenerations(generations []*Generation, file bool) {
+	if generationsJSON {
+		data, err := json.MarshalIndent(generations, "", "  ")
+		if err != nil {
+			fmt.Printf("Failed to marshal generations: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if file {
+		fmt.Fprintln(w, "GENERATION\tCREATED\tSIZE\tMODIFIED\tSHA256")
+	} else {
+		fmt.Fprintln(w, "GENERATION\tCREATED\tVERSION\tFILES\tSIZE")
+	}
+	for _, generation := range generations {
+		created := "-"
+		if generation.Created != "" {
+			created = generation.Created
+		}
+
+		if !file {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", generation.Name, created, generation.Version, generation.Files, generation.Size)
+			continue
+		}
+
+		entry := generation.File
+		modTime := "-"
+		if entry.ModTime != "" {
+			modTime = entry.ModTime
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", generation.Name, created, entry.Size, modTime, entry.Sum[:min(12, len(entry.Sum))])
+	}
+	w.Flush()
+}
+
+func init() {
+	generationsCmd.Flags().BoolVar(&generationsJSON, "json", false, "Output as JSON")
+	rootCmd.AddCommand(generationsCmd)
+}
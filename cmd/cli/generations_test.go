@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+func TestListGenerations(t *testing.T) {
+	setupCLI(t)
+
+	const (
+		older = repository.IndexPrefix + "20240101T000000.000000000Z.gosafe"
+		newer = repository.IndexPrefix + "20240102T000000.000000000Z.gosafe"
+	)
+
+	backend := storagetest.NewMemoryBackend()
+	b := testPipeline(t, backend, "repo")
+	storeIndex(t, b, older, map[string]*File{"a.txt": {Sum: "old", Size: 1}})
+	storeIndex(t, b, newer, map[string]*File{"a.txt": {Sum: "new", Size: 2}, "b.txt": {Sum: "b", Size: 3}})
+	// Unreadable generations are skipped
+	backend.Put(repository.IndexPrefix+"20240103T000000.000000000Z.gosafe", []byte("garbage"))
+
+	tests := []struct {
+		name, path string
+		// Names of the generations listed, and sums of the file in each
+		generations []string
+		sums        []string
+	}{
+		{"every generation", "", []string{"20240102T000000.000000000Z", "20240101T000000.000000000Z"}, nil},
+		{"file in every generation", "a.txt", []string{"20240102T000000.000000000Z", "20240101T000000.000000000Z"}, []string{"new", "old"}},
+		{"file in the newest generation", "b.txt", []string{"20240102T000000.000000000Z"}, []string{"b"}},
+		{"unknown file", "c.txt", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generations := listGenerations(b, tt.path)
+			if len(generations) != len(tt.generations) {
+				t.Fatalf("Expected %d generations, got %d", len(tt.generations), len(generations))
+			}
+			for i, generation := range generations {
+				if generation.Name != tt.generations[i] {
+					t.Errorf("Expected generation %s, got %s", tt.generations[i], generation.Name)
+				}
+				if tt.path == "" {
+					continue
+				}
+				if generation.File == nil || generation.File.Sum != tt.sums[i] {
+					t.Errorf("Expected %s to have sum %s in %s, got %+v", tt.path, tt.sums[i], generation.Name, generation.File)
+				}
+			}
+		})
+	}
+
+	// --generation reads the one asked for rather than the newest
+	for _, name := range []string{"20240101T000000.000000000Z", older} {
+		config.Generation = name
+		loadDatabase(b)
+		if databaseKey != older || manifestKey != repository.ManifestKey(older) || database["a.txt"].Sum != "old" {
+			t.Errorf("Expected --generation %s to load %s, got %s", name, older, databaseKey)
+		}
+	}
+}
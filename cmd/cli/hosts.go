@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+)
+
+var hostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "List the hosts backed up in the repository, to restore from with --host",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if config.S3.AccessID == "" {
+			fmt.Println("No storage backend configured")
+			os.Exit(1)
+		}
+
+		// Everything listed is stored in plain, no decryption key is needed
+		root := s3BackendAt(config.S3.Dir)
+		repo, err := repository.Load(root)
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Println("Repository has no config, it stores a single host at its root")
+			return
+		}
+		if err != nil {
+			fmt.Printf("Failed to open repository: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Repositories from before the config may have no ID
+		id := repo.ID
+		if id == "" {
+			id = "without ID"
+		}
+		fmt.Printf("Repository %s (format %d, %s), created %s\n",
+			id, repo.Version, describeEncryption(repo.Encryption), repo.Created.Local().Format(time.RFC3339))
+
+		if !repo.Namespaces {
+			fmt.Println("Repository stores a single host at its root")
+			return
+		}
+
+		hosts, err := repository.Hosts(root)
+		if err != nil {
+			fmt.Printf("Failed to list hosts: %v\n", err)
+			os.Exit(1)
+		}
+		if len(hosts) == 0 {
+			fmt.Println("No host backed up yet")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tLAST BACKUP\tOBJECTS\tSIZE")
+		for _, h := range hosts {
+			lastBackup := "-"
+			if !h.LastBackup.IsZero() {
+				lastBackup = h.LastBackup.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", h.Name, lastBackup, h.Objects, h.Size)
+		}
+		w.Flush()
+	},
+}
+
+// describeEncryption returns the encryption backend and its parameters, e.g.
+// "hpke encryption (aead=aes256gcm, kdf=sha256, kem=x25519)".
+func describeEncryption(e repository.Encryption) string {
+	description := e.Backend + " encryption"
+	if len(e.Parameters) == 0 {
+		return description
+	}
+
+	parameters := make([]string, 0, len(e.Parameters))
+	for name, value := range e.Parameters {
+		parameters = append(parameters, name+"="+value)
+	}
+	sort.Strings(parameters)
+
+	return description + " (" + strings.Join(parameters, ", ") + ")"
+}
+
+func init() {
+	rootCmd.AddCommand(hostsCmd)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Listing flags, shared by ls and find
+var (
+	listLong bool
+	listTree bool
+	listJSON bool
+)
+
+// Entry represents a file or a directory of the index, as listed.
+type Entry struct {
+	Path    string `json:"path"`
+	Dir     bool   `json:"dir,omitempty"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mtime,omitempty"`
+	Sum     string `json:"sum,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	// Files is the number of files in a directory
+	Files int `json:"files,omitempty"`
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "List the files of the backup, only downloading the index",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b := openStorage()
+		loadDatabase(b)
+
+		dir := ""
+		if len(args) == 1 {
+			dir = strings.Trim(filepath.ToSlash(args[0]), "/")
+		}
+
+		var entries []*Entry
+		if listTree {
+			entries = treeEntries(dir)
+		} else {
+			entries = dirEntries(dir)
+		}
+
+		if len(entries) == 0 {
+			fmt.Printf("No such file or directory: %s\n", dir)
+			os.Exit(1)
+		}
+
+		printEntries(entries)
+	},
+}
+
+// indexPaths returns the slash separated paths of the index, sorted.
+func indexPaths() []string {
+	paths := make([]string, 0, len(database))
+	for path := range database {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.ToSlash(paths[i]) < filepath.ToSlash(paths[j])
+	})
+	return paths
+}
+
+// fileEntry returns the entry of a file of the index.
+func fileEntry(path string) *Entry {
+	return indexEntry(path, database[path])
+}
+
+// indexEntry returns the entry of a file of any generation of the index.
+func indexEntry(path string, file *File) *Entry {
+	entry := &Entry{
+		Path:    filepath.ToSlash(path),
+		Size:    file.Size,
+		Sum:     file.Sum,
+		Version: file.Version,
+	}
+	if file.ModTime != 0 {
+		entry.ModTime = time.Unix(file.ModTime, 0).UTC().Format(time.RFC3339)
+	}
+
+	return entry
+}
+
+// dirEntries returns the entries directly in dir, or the file at dir.
+func dirEntries(dir string) []*Entry {
+	var entries []*Entry
+	dirs := make(map[string]*Entry)
+
+	for _, path := range indexPaths() {
+		p := filepath.ToSlash(path)
+		if p == dir {
+			return []*Entry{fileEntry(path)}
+		}
+
+		rel := p
+		if dir != "" {
+			if !strings.HasPrefix(p, dir+"/") {
+				continue
+			}
+			rel = p[len(dir)+1:]
+		}
+
+		// Files deeper down are summed up in their directory
+		name, _, nested := strings.Cut(rel, "/")
+		if !nested {
+			entries = append(entries, fileEntry(path))
+			continue
+		}
+
+		sub, ok := dirs[name]
+		if !ok {
+			sub = &Entry{Path: strings.TrimPrefix(dir+"/"+name, "/"), Dir: true}
+			dirs[name] = sub
+			entries = append(entries, sub)
+		}
+		sub.Size += database[path].Size
+		sub.Files++
+	}
+
+	return entries
+}
+
+// treeEntries returns every file under dir.
+func treeEntries(dir string) []*Entry {
+	var entries []*Entry
+	for _, path := range indexPaths() {
+		p := filepath.ToSlash(path)
+		if dir == "" || p == dir || strings.HasPrefix(p, dir+"/") {
+			entries = append(entries, fileEntry(path))
+		}
+	}
+	return entries
+}
+
+func printEntries(entries []*Entry) {
+	if listJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			fmt.Printf("Failed to marshal entries: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+
+	if !listLong {
+		for _, entry := range entries {
+			if entry.Dir {
+				fmt.Println(entry.Path + "/")
+			} else {
+				fmt.Println(entry.Path)
+			}
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tMODIFIED\tSHA256\tPATH")
+	for _, entry := range entries {
+		if entry.Dir {
+			files := fmt.Sprintf("%d files", entry.Files)
+			if entry.Files == 1 {
+				files = "1 file"
+			}
+			fmt.Fprintf(w, "%d\t-\t%s\t%s/\n", entry.Size, files, entry.Path)
+			continue
+		}
+
+		modTime := "-"
+		if entry.ModTime != "" {
+			modTime = entry.ModTime
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entry.Size, modTime, entry.Sum[:min(12, len(entry.Sum))], entry.Path)
+	}
+	w.Flush()
+}
+
+func init() {
+	lsCmd.Flags().BoolVarP(&listLong, "long", "l", false, "Long listing with size, modification time and hash")
+	lsCmd.Flags().BoolVar(&listTree, "tree", false, "List every file under the path, recursively")
+	lsCmd.Flags().BoolVar(&listJSON, "json", false, "Output as JSON")
+	rootCmd.AddCommand(lsCmd)
+}
@@ -0,0 +1,113 @@
+package main
+
+import "testing"
+
+// testIndex is the index browsed by the listing tests.
+var testIndex = map[string]*File{
+	"etc/passwd":             {Sum: "passwd", Size: 10, ModTime: 1704067200},
+	"etc/ssh/sshd_config":    {Sum: "sshd", Size: 20},
+	"etc/ssh/ssh_host_key":   {Sum: "hostkey", Size: 30},
+	"var/log/nginx/app.conf": {Sum: "app", Size: 40},
+	"readme.txt":             {Sum: "readme", Size: 50},
+}
+
+func TestDirEntries(t *testing.T) {
+	setupCLI(t)
+	database = testIndex
+
+	tests := []struct {
+		dir     string
+		entries []Entry
+	}{
+		{"", []Entry{
+			{Path: "etc", Dir: true, Size: 60, Files: 3},
+			{Path: "readme.txt", Size: 50, Sum: "readme"},
+			{Path: "var", Dir: true, Size: 40, Files: 1},
+		}},
+		{"etc", []Entry{
+			{Path: "etc/passwd", Size: 10, Sum: "passwd", ModTime: "2024-01-01T00:00:00Z"},
+			{Path: "etc/ssh", Dir: true, Size: 50, Files: 2},
+		}},
+		// A file lists itself
+		{"etc/passwd", []Entry{
+			{Path: "etc/passwd", Size: 10, Sum: "passwd", ModTime: "2024-01-01T00:00:00Z"},
+		}},
+		// Prefixes of names aren't directories
+		{"et", nil},
+		{"missing", nil},
+	}
+
+	for _, tt := range tests {
+		checkEntries(t, "ls "+tt.dir, dirEntries(tt.dir), tt.entries)
+	}
+}
+
+func TestTreeEntries(t *testing.T) {
+	setupCLI(t)
+	database = testIndex
+
+	tests := []struct {
+		dir   string
+		paths []string
+	}{
+		{"", []string{"etc/passwd", "etc/ssh/ssh_host_key", "etc/ssh/sshd_config", "readme.txt", "var/log/nginx/app.conf"}},
+		{"etc/ssh", []string{"etc/ssh/ssh_host_key", "etc/ssh/sshd_config"}},
+		{"var", []string{"var/log/nginx/app.conf"}},
+		{"etc/ss", nil},
+	}
+
+	for _, tt := range tests {
+		checkPaths(t, "ls --tree "+tt.dir, treeEntries(tt.dir), tt.paths)
+	}
+}
+
+func TestFindEntries(t *testing.T) {
+	setupCLI(t)
+	database = testIndex
+
+	tests := []struct {
+		pattern string
+		paths   []string
+	}{
+		// Patterns without a slash match names, at any depth
+		{"*.conf", []string{"var/log/nginx/app.conf"}},
+		{"ssh*", []string{"etc/ssh/ssh_host_key", "etc/ssh/sshd_config"}},
+		// Others match whole paths
+		{"etc/pass*", []string{"etc/passwd"}},
+		// Like on restore, a matching directory matches everything in it
+		{"etc/*", []string{"etc/passwd", "etc/ssh/ssh_host_key", "etc/ssh/sshd_config"}},
+		{"etc/**", []string{"etc/passwd", "etc/ssh/ssh_host_key", "etc/ssh/sshd_config"}},
+		{"**/*.conf", []string{"var/log/nginx/app.conf"}},
+		{"*.log", nil},
+	}
+
+	for _, tt := range tests {
+		checkPaths(t, "find "+tt.pattern, findEntries(tt.pattern), tt.paths)
+	}
+}
+
+func checkEntries(t *testing.T, command string, entries []*Entry, expected []Entry) {
+	t.Helper()
+	if len(entries) != len(expected) {
+		t.Errorf("%s: expected %d entries, got %d", command, len(expected), len(entries))
+		return
+	}
+	for i, entry := range entries {
+		if *entry != expected[i] {
+			t.Errorf("%s: expected %+v, got %+v", command, expected[i], *entry)
+		}
+	}
+}
+
+func checkPaths(t *testing.T, command string, entries []*Entry, expected []string) {
+	t.Helper()
+	if len(entries) != len(expected) {
+		t.Errorf("%s: expected %v, got %d entries", command, expected, len(entries))
+		return
+	}
+	for i, entry := range entries {
+		if entry.Path != expected[i] {
+			t.Errorf("%s: expected %s, got %s", command, expected[i], entry.Path)
+		}
+	}
+}
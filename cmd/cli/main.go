@@ -1,22 +1,20 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
 )
 
-var backupDir string
-
 // Create and configure the Cobra command
 var rootCmd = &cobra.Command{
-	Use: "go-safe",
+	Use:  "go-safe [-- path or glob...]",
+	Args: restoreArgs,
+	// Suggest the command a mistyped one is close to
+	SuggestionsMinimumDistance: 2,
 	Run: func(cmd *cobra.Command, args []string) {
 		if config.ECIES.GenKey {
 			eciesGenKey()
@@ -28,86 +26,95 @@ var rootCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
-		// Configure encryption backend
-		encryptionBackend := encryptionBackend()
-		if encryptionBackend == nil {
-			fmt.Println("No encryption backend configured")
-			os.Exit(1)
+		if config.Age.GenKey {
+			ageGenKey()
+			os.Exit(0)
 		}
 
-		// Configure storage backend
-		s3Backend := storageBackend(encryptionBackend)
-		if s3Backend == nil {
-			fmt.Println("No storage backend configured")
-			os.Exit(1)
+		if config.Sign.GenKey {
+			signGenKey()
+			os.Exit(0)
+		}
+
+		// Everything else printed goes to stderr, so that the report written
+		// to stdout can be piped as is
+		if config.Restore.Report == "-" || config.Restore.Report == "" {
+			reportOutput = os.Stdout
+			os.Stdout = os.Stderr
+		}
+
+		s3Backend := openStorage()
+
+		// Files are restored into the backup directory, unless remapped elsewhere
+		dir := config.Backup.Dir
+		if restoreTarget != "" {
+			dir = restoreTarget
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				fmt.Printf("Failed to create target directory: %v\n", err)
+				os.Exit(1)
+			}
 		}
 
 		// Check that backup directory exists and is a directory
-		if st, err := os.Stat(backupDir); err != nil || !st.IsDir() {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
 			fmt.Println("Backup directory does not exist or is not a directory")
 			os.Exit(1)
 		}
 
-		fmt.Println("Attempting to download in '", backupDir, "'...")
-		downloader(s3Backend)
+		filter := &pathFilter{
+			paths:   args,
+			include: restoreInclude,
+			exclude: restoreExclude,
+		}
+
+		fmt.Println("Attempting to download in '", dir, "'...")
+		report := downloader(s3Backend, filter, dir)
+		if len(report.Failed) > 0 {
+			os.Exit(1)
+		}
 	},
 }
 
-func main() {
-	// Execute the Cobra command
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// restoreArgs only accepts the paths to restore after "--", so that a mistyped
+// command isn't taken for a path and restored.
+func restoreArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 || cmd.ArgsLenAtDash() == 0 {
+		return nil
 	}
-}
 
-func downloader(b storage.StorageBackend) {
-	// Download db.gosafe from S3
-	data, err := b.Retrieve("db.gosafe")
-	if err != nil {
-		fmt.Printf("Failed to download db.gosafe from S3: %v\n", err)
-		os.Exit(1)
+	message := fmt.Sprintf("unknown command %q for %q", args[0], cmd.CommandPath())
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		message += ", did you mean " + strings.Join(suggestions, " or ") + "?"
 	}
+	return fmt.Errorf("%s\nPaths to restore go after --, e.g. %s -- 'etc/nginx/**'", message, cmd.CommandPath())
+}
 
-	err = json.Unmarshal(data, &database)
-	if err != nil {
-		fmt.Printf("Failed to unmarshal db.gosafe: %v\n", err)
+// openStorage configures the encryption and storage backends, exiting if either is missing.
+func openStorage() *transform.Pipeline {
+	// Configure encryption backend
+	encryptionBackend := encryptionBackend()
+	if encryptionBackend == nil {
+		fmt.Println("No encryption backend configured")
 		os.Exit(1)
 	}
 
-	// Download all files from S3
-	for path, file := range database {
-		savePath := filepath.Join(backupDir, path)
-		data, err := b.Retrieve(path)
-		if err != nil {
-			fmt.Printf("Failed to download %s from S3: %v\n", path, err)
-		}
+	// Find the namespace of the host to restore from
+	openRepository()
 
-		// Check the SHA256 hash of the file
-		hash := sha256.Sum256(data)
-		if hex.EncodeToString(hash[:]) != file.Sum {
-			fmt.Printf("Failed to verify hash of %s, be careful\n", path)
-		}
+	// Configure storage backend
+	s3Backend := storageBackend(encryptionBackend)
+	if s3Backend == nil {
+		fmt.Println("No storage backend configured")
+		os.Exit(1)
+	}
 
-		// Check if we need to create any directories
-		dir := filepath.Dir(savePath)
-		st, err := os.Stat(dir)
-		if err != nil {
-			// mkdir -p
-			err = os.MkdirAll(dir, 0700)
-			if err != nil {
-				fmt.Printf("Failed to create directory: %v\n", err)
-				os.Exit(1)
-			}
-		} else if !st.IsDir() {
-			fmt.Printf("Failed to create directory: %s is not a directory\n", dir)
-			os.Exit(1)
-		}
+	return s3Backend
+}
 
-		// Write the file to disk
-		err = os.WriteFile(savePath, data, 0600)
-		if err != nil {
-			fmt.Printf("Failed to write %s to disk: %v\n", savePath, err)
-		}
+func main() {
+	// Execute the Cobra command
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 }
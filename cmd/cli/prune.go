@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/lock"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+// Pruning flags
+var (
+	pruneDryRun bool
+	pruneMinAge time.Duration
+	pruneKeep   int
+	pruneRoot   bool
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete the objects which no snapshot references anymore",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if pruneKeep < 0 || pruneMinAge < 0 {
+			fmt.Println("Number of generations to keep and minimum age cannot be negative")
+			os.Exit(1)
+		}
+
+		b := openStorage()
+
+		if err := pruneAllowed(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Locks are stored directly in S3, where the daemon can read them
+		raw := s3Backend()
+		locker, err := lock.NewLocker(raw, lock.DefaultTTL)
+		if err != nil {
+			fmt.Printf("Failed to configure locking: %v\n", err)
+			os.Exit(1)
+		}
+
+		// No daemon may write while the references are collected
+		held, err := locker.Lock(true)
+		if err != nil {
+			fmt.Printf("Failed to lock repository: %v\n", err)
+			os.Exit(1)
+		}
+
+		stop := locker.KeepAlive(held, func(err error) {
+			fmt.Printf("Failed to refresh lock: %v\n", err)
+		})
+		failed := prune(b, raw, held)
+		stop()
+
+		err = locker.Unlock(held)
+		if err != nil {
+			fmt.Printf("Failed to unlock repository: %v\n", err)
+			os.Exit(1)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// pruneAllowed returns an error if every object of the bucket would be a
+// candidate for deletion, unless --bucket-root is given.
+func pruneAllowed() error {
+	if atBucketRoot() && !pruneRoot {
+		return errors.New("repository is stored at the root of the bucket, objects of other applications would be deleted: set --s3.dir, or use --bucket-root if the bucket only holds this repository")
+	}
+	return nil
+}
+
+// prune deletes the unreferenced objects and the stale locks, returning
+// whether anything failed.
+func prune(b *transform.Pipeline, raw storage.StorageBackend, held *lock.Lock) bool {
+	objects, err := b.List("")
+	if err != nil {
+		fmt.Printf("Failed to list objects: %v\n", err)
+		return true
+	}
+
+	// Generations of the index, oldest first, beyond the ones to keep
+	var generations, indexes []string
+	for _, object := range objects {
+		if strings.HasPrefix(object.Key, repository.IndexPrefix) {
+			generations = append(generations, object.Key)
+		}
+		if object.Key == "db.gosafe" {
+			indexes = append(indexes, object.Key)
+		}
+	}
+	sort.Strings(generations)
+	if pruneKeep > 0 && len(generations) > pruneKeep {
+		generations = generations[len(generations)-pruneKeep:]
+	}
+	indexes = append(indexes, generations...)
+
+	referenced := map[string]bool{
+		repository.ConfigKey: true,
+		"db.gosafe":          true,
+		"manifest.gosafe":    true,
+		repository.HeadKey:   true,
+	}
+	for _, key := range indexes {
+		referenced[key] = true
+		referenced[repository.ManifestKey(key)] = true
+
+		// Deleting what an unreadable index references would destroy backups
+		data, _, err := b.RetrieveVersion(key)
+		if err != nil {
+			fmt.Printf("Failed to download %s, nothing was pruned: %v\n", key, err)
+			return true
+		}
+		index := make(map[string]*File)
+		if err := json.Unmarshal(data, &index); err != nil {
+			fmt.Printf("Failed to unmarshal %s, nothing was pruned: %v\n", key, err)
+			return true
+		}
+
+		for path, file := range index {
+			referenced[file.objectKey(path)] = true
+		}
+	}
+
+	// Uploads which are not in an index yet are protected by the minimum age
+	cutoff := time.Now().Add(-pruneMinAge)
+	failed := false
+	var pruned, recent int
+	var prunedBytes int64
+	for _, object := range objects {
+		if referenced[object.Key] || strings.HasPrefix(object.Key, lock.Prefix) {
+			continue
+		}
+		if object.LastModified.After(cutoff) {
+			recent++
+			continue
+		}
+
+		if pruneDryRun {
+			fmt.Printf("Would delete %s (%d bytes)\n", object.Key, object.Size)
+		} else {
+			fmt.Printf("Deleting %s (%d bytes)...\n", object.Key, object.Size)
+			if err := b.Delete(object.Key); err != nil {
+				fmt.Printf("Failed to delete %s: %v\n", object.Key, err)
+				failed = true
+				continue
+			}
+		}
+		pruned++
+		prunedBytes += object.Size
+	}
+
+	// Locks which were never released, e.g. by an append-only daemon
+	locks, err := lock.List(raw)
+	if err != nil {
+		fmt.Printf("Failed to list locks: %v\n", err)
+		return true
+	}
+	stale := 0
+	for _, l := range locks {
+		if l.ID == held.ID || !l.Expired(time.Now()) {
+			continue
+		}
+
+		if pruneDryRun {
+			fmt.Printf("Would delete stale lock of %s\n", l.Hostname)
+		} else if err := raw.Delete(l.Key()); err != nil {
+			fmt.Printf("Failed to delete stale lock of %s: %v\n", l.Hostname, err)
+			failed = true
+			continue
+		}
+		stale++
+	}
+
+	verb := "Deleted"
+	if pruneDryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%s %d unreferenced objects (%d bytes) and %d stale locks, %d indexes kept, %d recent objects kept\n",
+		verb, pruned, prunedBytes, stale, len(indexes), recent)
+
+	return failed
+}
+
+func init() {
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Print what would be deleted, without deleting anything")
+	pruneCmd.Flags().DurationVar(&pruneMinAge, "min-age", 24*time.Hour, "Never delete objects younger than this, they may belong to an upload in progress")
+	pruneCmd.Flags().IntVar(&pruneKeep, "keep", 0, "Number of generations of the index to keep, 0 to keep them all")
+	pruneCmd.Flags().BoolVar(&pruneRoot, "bucket-root", false, "Prune a repository stored at the root of the bucket, deleting any object of the bucket it doesn't reference")
+	rootCmd.AddCommand(pruneCmd)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/yyewolf/go-safe/lock"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+func TestPrune(t *testing.T) {
+	const (
+		older = repository.IndexPrefix + "20240101T000000.000000000Z.gosafe"
+		newer = repository.IndexPrefix + "20240102T000000.000000000Z.gosafe"
+	)
+
+	tests := []struct {
+		name   string
+		keep   int
+		minAge time.Duration
+		dryRun bool
+		// Objects left once pruned, besides the repository objects
+		left []string
+	}{
+		{
+			name: "unreferenced objects",
+			left: []string{"a.txt", "old.txt", older, repository.ManifestKey(older), newer, repository.ManifestKey(newer)},
+		},
+		{
+			name:   "recent objects",
+			minAge: time.Hour,
+			left:   []string{"a.txt", "old.txt", "orphan.txt", older, repository.ManifestKey(older), newer, repository.ManifestKey(newer)},
+		},
+		{
+			name: "generations beyond the ones to keep",
+			keep: 1,
+			left: []string{"a.txt", newer, repository.ManifestKey(newer)},
+		},
+		{
+			name:   "dry run",
+			keep:   1,
+			dryRun: true,
+			left:   []string{"a.txt", "old.txt", "orphan.txt", older, repository.ManifestKey(older), newer, repository.ManifestKey(newer)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupCLI(t)
+			setupPrune(t, tt.keep, tt.minAge, tt.dryRun)
+
+			backend := storagetest.NewMemoryBackend()
+			b := testPipeline(t, backend, "repo")
+			storeObject(t, b, "old.txt")
+			storeObject(t, b, "a.txt")
+			storeObject(t, b, "orphan.txt")
+			storeIndex(t, b, older, map[string]*File{"old.txt": {Version: 1}})
+			storeIndex(t, b, newer, map[string]*File{"a.txt": {Version: 1}})
+			backend.Put(repository.HeadKey, []byte(newer))
+
+			held := lockPrune(t, backend)
+			time.Sleep(time.Millisecond)
+
+			if failed := prune(b, backend, held); failed {
+				t.Fatal("Expected prune to succeed")
+			}
+
+			left := append([]string{repository.HeadKey, held.Key()}, tt.left...)
+			sort.Strings(left)
+			if keys := keys(backend); !equal(keys, left) {
+				t.Errorf("Expected %v to be left, got %v", left, keys)
+			}
+		})
+	}
+}
+
+func TestPruneLocks(t *testing.T) {
+	setupCLI(t)
+	setupPrune(t, 0, 0, false)
+
+	backend := storagetest.NewMemoryBackend()
+	b := testPipeline(t, backend, "repo")
+
+	// A lock which was never released, e.g. by a killed daemon
+	stale, err := lock.NewLocker(backend, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stale.Lock(false); err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	held := lockPrune(t, backend)
+	if failed := prune(b, backend, held); failed {
+		t.Fatal("Expected prune to succeed")
+	}
+
+	locks, err := lock.List(backend)
+	if err != nil {
+		t.Fatalf("Failed to list locks: %v", err)
+	}
+	if len(locks) != 1 || locks[0].ID != held.ID {
+		t.Errorf("Expected only the lock of prune to be left, got %d locks", len(locks))
+	}
+}
+
+func TestPruneUnreadableIndex(t *testing.T) {
+	setupCLI(t)
+	setupPrune(t, 0, 0, false)
+
+	backend := storagetest.NewMemoryBackend()
+	b := testPipeline(t, backend, "repo")
+	storeObject(t, b, "a.txt")
+	backend.Put(repository.IndexPrefix+"20240101T000000.000000000Z.gosafe", []byte("garbage"))
+
+	held := lockPrune(t, backend)
+	time.Sleep(time.Millisecond)
+
+	// Deleting what an unreadable index references would destroy backups
+	if failed := prune(b, backend, held); !failed {
+		t.Error("Expected prune to fail")
+	}
+	if backend.Get("a.txt") == nil {
+		t.Error("Expected nothing to be pruned")
+	}
+}
+
+func TestPruneAllowed(t *testing.T) {
+	tests := []struct {
+		dir, namespace string
+		bucketRoot     bool
+		allowed        bool
+	}{
+		{"", "", false, false},
+		{"/", "", false, false},
+		{"", "", true, true},
+		{"backups", "", false, true},
+		{"", "hosts/web-1/", false, true},
+	}
+
+	for _, tt := range tests {
+		setupCLI(t)
+		setupPrune(t, 0, 0, false)
+		config.S3.Dir, namespace, pruneRoot = tt.dir, tt.namespace, tt.bucketRoot
+
+		if err := pruneAllowed(); tt.allowed != (err == nil) {
+			t.Errorf("Expected pruning %q in namespace %q (--bucket-root %v) to be allowed: %v, got %v",
+				tt.dir, tt.namespace, tt.bucketRoot, tt.allowed, err)
+		}
+	}
+}
+
+// setupPrune sets the pruning flags, restoring them once the test is over.
+func setupPrune(t *testing.T, keep int, minAge time.Duration, dryRun bool) {
+	savedKeep, savedMinAge, savedDryRun, savedRoot := pruneKeep, pruneMinAge, pruneDryRun, pruneRoot
+	t.Cleanup(func() {
+		pruneKeep, pruneMinAge, pruneDryRun, pruneRoot = savedKeep, savedMinAge, savedDryRun, savedRoot
+	})
+
+	pruneKeep, pruneMinAge, pruneDryRun, pruneRoot = keep, minAge, dryRun, false
+}
+
+// lockPrune takes the lock of prune on the backend.
+func lockPrune(t *testing.T, backend *storagetest.MemoryBackend) *lock.Lock {
+	locker, err := lock.NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := locker.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+	return held
+}
+
+// storeObject stores the first version of a file under its path.
+func storeObject(t *testing.T, b *transform.Pipeline, path string) {
+	if err := b.StoreFile(path, 1, []byte(path), &transform.Metadata{Path: path}); err != nil {
+		t.Fatalf("Failed to store %s: %v", path, err)
+	}
+}
+
+// storeIndex stores a generation of the index along with its manifest.
+func storeIndex(t *testing.T, b *transform.Pipeline, key string, index map[string]*File) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Store(key, 1, data); err != nil {
+		t.Fatalf("Failed to store %s: %v", key, err)
+	}
+	if err := b.Store(repository.ManifestKey(key), 1, []byte("{}")); err != nil {
+		t.Fatalf("Failed to store the manifest of %s: %v", key, err)
+	}
+}
+
+// keys returns the sorted keys of the objects of the backend.
+func keys(backend *storagetest.MemoryBackend) []string {
+	objects, _ := backend.List("")
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
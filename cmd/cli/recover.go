@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+// Index recovery flags
+var (
+	recoverOutput     string
+	recoverMaxVersion uint64
+)
+
+// versionSuffix matches the version in the keys of the append-only mode.
+var versionSuffix = regexp.MustCompile(`@v(\d+)-\d+$`)
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover-index",
+	Short: "Rebuild a lost index by decrypting every object of the bucket",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		b := openStorage()
+		raw := s3Backend()
+
+		objects, err := b.List("")
+		if err != nil {
+			fmt.Printf("Failed to list objects: %v\n", err)
+			os.Exit(1)
+		}
+
+		index, superseded, unreadable := recoverIndex(b, raw, objects)
+
+		data, err := json.Marshal(index)
+		if err != nil {
+			fmt.Printf("Failed to marshal index: %v\n", err)
+			os.Exit(1)
+		}
+
+		err = os.WriteFile(recoverOutput, data, 0600)
+		if err != nil {
+			fmt.Printf("Failed to write index: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Recovered %d files from %d objects into %s: %d older versions skipped, %d unreadable\n",
+			len(index), len(objects), recoverOutput, superseded, unreadable)
+
+		if unreadable > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// recoverIndex rebuilds the index from the objects, downloaded from raw and
+// decoded with b, keeping the newest version of every file. It also returns the
+// number of older versions skipped and of unreadable objects.
+func recoverIndex(b *transform.Pipeline, raw storage.StorageBackend, objects []storage.ObjectInfo) (map[string]*File, int, int) {
+	index := make(map[string]*File)
+	var unreadable, superseded int
+	for _, object := range objects {
+		if repositoryObject(object.Key) {
+			continue
+		}
+
+		data, err := raw.Retrieve(object.Key, 0)
+		if err != nil {
+			fmt.Printf("Failed to download %s: %v\n", object.Key, err)
+			unreadable++
+			continue
+		}
+
+		content, metadata, version, err := decodeObject(b, object.Key, data)
+		if err != nil {
+			fmt.Printf("Unreadable: %s: %v\n", object.Key, err)
+			unreadable++
+			continue
+		}
+
+		hash := sha256.Sum256(content)
+		file := &File{
+			Sum:     hex.EncodeToString(hash[:]),
+			Version: version,
+			Size:    int64(len(content)),
+			ModTime: object.LastModified.Unix(),
+		}
+
+		// Objects stored without metadata are recovered under their key
+		path := object.Key
+		if metadata != nil {
+			if metadata.Sum != file.Sum {
+				fmt.Printf("Corrupt: %s\n", object.Key)
+				unreadable++
+				continue
+			}
+			path = metadata.Path
+			file.ModTime = metadata.ModTime
+			file.Mode = metadata.Mode
+		}
+		if path != object.Key {
+			file.Key = object.Key
+		}
+
+		// Older versions of a file are left behind in append-only mode
+		if existing, ok := index[path]; ok {
+			superseded++
+			if existing.Version > file.Version || (existing.Version == file.Version && existing.ModTime >= file.ModTime) {
+				continue
+			}
+		}
+		index[path] = file
+	}
+
+	return index, superseded, unreadable
+}
+
+// decodeObject decodes an object whose version is unknown, since the version
+// is bound to the object. It is read from the envelope, except for objects
+// stored before envelopes recorded it: the version in the key of the
+// append-only mode is tried first, then every version up to the maximum.
+func decodeObject(b *transform.Pipeline, key string, data []byte) ([]byte, *transform.Metadata, uint64, error) {
+	if version, err := transform.Version(data); err == nil {
+		content, metadata, err := b.Decode(key, version, data)
+		return content, metadata, version, err
+	}
+
+	var versions []uint64
+	if match := versionSuffix.FindStringSubmatch(key); match != nil {
+		if version, err := strconv.ParseUint(match[1], 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	for version := uint64(0); version <= recoverMaxVersion; version++ {
+		versions = append(versions, version)
+	}
+
+	var err error
+	for _, version := range versions {
+		var content []byte
+		var metadata *transform.Metadata
+		content, metadata, err = b.Decode(key, version, data)
+		if err == nil {
+			return content, metadata, version, nil
+		}
+	}
+
+	return nil, nil, 0, err
+}
+
+func init() {
+	recoverCmd.Flags().StringVar(&recoverOutput, "output", "recovered-db.gosafe", "Where to write the recovered index")
+	recoverCmd.Flags().Uint64Var(&recoverMaxVersion, "max-version", 100, "Highest version to try when decrypting an object which does not record its version")
+	rootCmd.AddCommand(recoverCmd)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+func TestRecoverIndex(t *testing.T) {
+	// An object stored by the daemon, under key
+	type object struct {
+		key, path, content string
+		version            uint64
+	}
+
+	tests := []struct {
+		name    string
+		objects []object
+		// Raw objects, which don't decode
+		raw []string
+		// Keys and versions recovered for each path
+		keys                   map[string]string
+		versions               map[string]uint64
+		superseded, unreadable int
+	}{
+		{
+			name:     "plain names",
+			objects:  []object{{"etc/passwd", "etc/passwd", "root", 3}},
+			keys:     map[string]string{"etc/passwd": ""},
+			versions: map[string]uint64{"etc/passwd": 3},
+		},
+		{
+			name:     "opaque names",
+			objects:  []object{{"ab/abcdef", "etc/passwd", "root", 1}},
+			keys:     map[string]string{"etc/passwd": "ab/abcdef"},
+			versions: map[string]uint64{"etc/passwd": 1},
+		},
+		{
+			name: "older versions",
+			objects: []object{
+				{"etc/passwd@v2-200", "etc/passwd", "new", 2},
+				{"etc/passwd@v1-100", "etc/passwd", "old", 1},
+			},
+			keys:       map[string]string{"etc/passwd": "etc/passwd@v2-200"},
+			versions:   map[string]uint64{"etc/passwd": 2},
+			superseded: 1,
+		},
+		{
+			name:       "undecryptable",
+			objects:    []object{{"etc/passwd", "etc/passwd", "root", 1}},
+			raw:        []string{"etc/shadow"},
+			keys:       map[string]string{"etc/passwd": ""},
+			versions:   map[string]uint64{"etc/passwd": 1},
+			unreadable: 1,
+		},
+		{
+			name: "repository objects",
+			raw:  []string{repository.HeadKey, repository.IndexPrefix + "20240101T000000.000000000Z.gosafe"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupCLI(t)
+			backend := storagetest.NewMemoryBackend()
+			b := testPipeline(t, backend, "repo")
+
+			for _, o := range tt.objects {
+				hash := sha256.Sum256([]byte(o.content))
+				metadata := &transform.Metadata{Path: o.path, Sum: hex.EncodeToString(hash[:])}
+				if err := b.StoreFile(o.key, o.version, []byte(o.content), metadata); err != nil {
+					t.Fatalf("Failed to store %s: %v", o.key, err)
+				}
+			}
+			for _, key := range tt.raw {
+				backend.Put(key, []byte("garbage"))
+			}
+
+			objects, err := backend.List("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, superseded, unreadable := recoverIndex(b, backend, objects)
+
+			if superseded != tt.superseded || unreadable != tt.unreadable {
+				t.Errorf("Expected %d superseded and %d unreadable objects, got %d and %d",
+					tt.superseded, tt.unreadable, superseded, unreadable)
+			}
+			if len(index) != len(tt.keys) {
+				t.Fatalf("Expected %d files to be recovered, got %d", len(tt.keys), len(index))
+			}
+			for path, key := range tt.keys {
+				file, ok := index[path]
+				if !ok {
+					t.Errorf("Expected %s to be recovered", path)
+					continue
+				}
+				if file.Key != key || file.Version != tt.versions[path] {
+					t.Errorf("Expected %s under %q at version %d, got %q at version %d",
+						path, key, tt.versions[path], file.Key, file.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverIndexCorrupt(t *testing.T) {
+	setupCLI(t)
+	backend := storagetest.NewMemoryBackend()
+	b := testPipeline(t, backend, "repo")
+
+	// The content doesn't match the hash recorded along with it
+	metadata := &transform.Metadata{Path: "etc/passwd", Sum: "0000"}
+	if err := b.StoreFile("etc/passwd", 1, []byte("root"), metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := backend.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _, unreadable := recoverIndex(b, backend, objects)
+	if len(index) != 0 || unreadable != 1 {
+		t.Errorf("Expected the corrupt object to be unreadable, got %d files and %d unreadable", len(index), unreadable)
+	}
+}
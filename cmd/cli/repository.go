@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// host is the host restored from, namespace the prefix of its objects in the
+// repository (empty for repositories from before namespaces), and
+// repositoryID the ID bound to its objects.
+var host, namespace, repositoryID string
+
+// openRepository loads the repository config and selects the host to restore
+// from, exiting on failure.
+func openRepository() {
+	if config.S3.AccessID == "" {
+		fmt.Println("No storage backend configured")
+		os.Exit(1)
+	}
+
+	repositoryID = config.Repository.ID
+
+	// The config is stored directly in S3, outside of the namespaces
+	repo, err := repository.Load(s3BackendAt(config.S3.Dir))
+	if errors.Is(err, storage.ErrNotFound) {
+		// Repositories from before the config store a single host at their root
+		if config.Host != "" {
+			fmt.Println("Repository has no host namespaces, --host cannot be used")
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = repo.Check(config.Repository.ID, encryptionName())
+	if err != nil {
+		fmt.Printf("Cannot restore from this repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	repositoryID = repo.ID
+	if !repo.Namespaces {
+		if config.Host != "" {
+			fmt.Println("Repository has no host namespaces, --host cannot be used")
+			os.Exit(1)
+		}
+		return
+	}
+
+	host = hostID()
+	namespace = repo.Namespace(host)
+	repositoryID = repo.BoundID(host)
+}
+
+// atBucketRoot returns whether the objects of the host are stored at the root
+// of the bucket, along with whatever else the bucket holds.
+func atBucketRoot() bool {
+	return strings.Trim(config.S3.Dir, "/") == "" && namespace == ""
+}
+
+// hostID returns the host to restore from: --host, else the one the daemon
+// recorded in the backup directory, else the hostname.
+func hostID() string {
+	id := config.Host
+	if id == "" {
+		data, err := os.ReadFile(filepath.Join(config.Backup.Dir, "host.gosafe"))
+		switch {
+		case err == nil:
+			id = strings.TrimSpace(string(data))
+		case errors.Is(err, os.ErrNotExist):
+			id, err = os.Hostname()
+			if err != nil {
+				fmt.Printf("Failed to get hostname, use --host: %v\n", err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("Failed to read host identity: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := repository.ValidateHost(id); err != nil {
+		fmt.Printf("Invalid host: %v\n", err)
+		os.Exit(1)
+	}
+
+	return id
+}
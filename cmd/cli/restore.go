@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/transform"
+)
+
+// RestoreReport lists the outcome of a restore for every file.
+type RestoreReport struct {
+	Restored    []string         `json:"restored"`
+	Skipped     []string         `json:"skipped"`
+	Failed      []RestoreFailure `json:"failed"`
+	Quarantined []string         `json:"quarantined"`
+}
+
+// RestoreFailure describes a file which could not be restored.
+type RestoreFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Restore selection flags
+var (
+	restoreInclude []string
+	restoreExclude []string
+	restoreTarget  string
+)
+
+func init() {
+	rootCmd.Flags().StringSliceVar(&restoreInclude, "include", nil, "Only restore files matching one of these globs (\"**\" matches directories)")
+	rootCmd.Flags().StringSliceVar(&restoreExclude, "exclude", nil, "Don't restore files matching any of these globs")
+	rootCmd.Flags().StringVar(&restoreTarget, "target", "", "Restore into this directory instead of the backup directory")
+}
+
+func downloader(b *transform.Pipeline, filter *pathFilter, dir string) *RestoreReport {
+	// Download the index from S3
+	loadDatabase(b)
+
+	report := &RestoreReport{
+		Restored:    []string{},
+		Skipped:     []string{},
+		Failed:      []RestoreFailure{},
+		Quarantined: []string{},
+	}
+
+	paths := make([]string, 0, len(database))
+	for path := range database {
+		if filter.match(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	if len(paths) == 0 {
+		fmt.Println("No file matches the selection")
+	}
+
+	// Download all files from S3
+	for _, path := range paths {
+		file := database[path]
+
+		// Never write outside of the backup directory
+		if !filepath.IsLocal(path) {
+			fmt.Printf("Refusing to restore %s outside of the backup directory\n", path)
+			report.Failed = append(report.Failed, RestoreFailure{path, "path escapes the backup directory"})
+			continue
+		}
+		savePath := filepath.Join(dir, path)
+
+		// Don't rewrite files which are already intact
+		if sum, err := fileSum(savePath); err == nil && sum == file.Sum {
+			report.Skipped = append(report.Skipped, path)
+			continue
+		}
+
+		data, err := b.Retrieve(file.objectKey(path), file.Version)
+		if err != nil {
+			fmt.Printf("Failed to download %s from S3: %v\n", path, err)
+			report.Failed = append(report.Failed, RestoreFailure{path, err.Error()})
+			continue
+		}
+
+		// Check the SHA256 hash of the file
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != file.Sum {
+			if config.Restore.Strict {
+				fmt.Printf("Failed to verify hash of %s, quarantining it\n", path)
+				report.Failed = append(report.Failed, RestoreFailure{path, "hash mismatch"})
+				if err := quarantine(dir, path, data); err != nil {
+					fmt.Printf("Failed to quarantine %s: %v\n", path, err)
+				} else {
+					report.Quarantined = append(report.Quarantined, path)
+				}
+				continue
+			}
+			fmt.Printf("Failed to verify hash of %s, be careful\n", path)
+		}
+
+		// Write the file next to its destination, then move it into place
+		err = writeVerified(savePath, data, hex.EncodeToString(hash[:]))
+		if err != nil {
+			fmt.Printf("Failed to write %s to disk: %v\n", savePath, err)
+			report.Failed = append(report.Failed, RestoreFailure{path, err.Error()})
+			continue
+		}
+
+		report.Restored = append(report.Restored, path)
+	}
+
+	writeReport(report)
+	return report
+}
+
+// writeVerified writes data to a temporary file, checks what was written and
+// renames it to path, so that path is never left half written.
+func writeVerified(path string, data []byte, sum string) error {
+	// Check if we need to create any directories
+	dir := filepath.Dir(path)
+	st, err := os.Stat(dir)
+	if err != nil {
+		// mkdir -p
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+	} else if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// Next to the file so that it can be renamed over it, the daemon skips it
+	tmp, err := os.CreateTemp(dir, repository.TempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	written, err := fileSum(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if written != sum {
+		return errors.New("written file does not match its hash")
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// quarantine writes a file which failed verification away from the restored
+// tree, by default under the reserved prefix, so that the daemon doesn't back
+// it up when restoring into the backup directory.
+func quarantine(restoreDir string, path string, data []byte) error {
+	dir := config.Restore.QuarantineDir
+	if dir == "" {
+		dir = filepath.Join(restoreDir, filepath.FromSlash(repository.Prefix), "quarantine")
+	}
+
+	quarantinePath := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(quarantinePath, data, 0600)
+}
+
+// fileSum returns the hex encoded SHA256 sum of a file.
+func fileSum(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reportOutput is the stdout of the process when the report is written to it,
+// everything else being printed to stderr.
+var reportOutput *os.File
+
+func writeReport(report *RestoreReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to marshal restore report: %v\n", err)
+		return
+	}
+
+	if config.Restore.Report == "-" || config.Restore.Report == "" {
+		reportOutput.Write(append(data, '\n'))
+		return
+	}
+
+	err = os.WriteFile(config.Restore.Report, data, 0600)
+	if err != nil {
+		fmt.Printf("Failed to write restore report: %v\n", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yyewolf/go-safe/repository"
+)
+
+func TestWriteVerified(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("content")
+	sum := sha256.Sum256(data)
+
+	tests := []struct {
+		name, path, sum string
+		written         bool
+	}{
+		{"new directory", "etc/ssh/sshd_config", hex.EncodeToString(sum[:]), true},
+		{"hash mismatch", "etc/passwd", "0000", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, filepath.FromSlash(tt.path))
+			err := writeVerified(path, data, tt.sum)
+			if tt.written != (err == nil) {
+				t.Fatalf("Expected written to be %v, got %v", tt.written, err)
+			}
+
+			_, err = os.Stat(path)
+			if tt.written != (err == nil) {
+				t.Errorf("Expected the file to exist to be %v, got %v", tt.written, err)
+			}
+
+			// No temporary file is left behind
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if repository.Temporary(entry.Name()) {
+					t.Errorf("Temporary file %s was left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	setupCLI(t)
+	dir := t.TempDir()
+
+	// By default, under the reserved prefix, which the daemon never backs up
+	if err := quarantine(dir, "etc/passwd", []byte("corrupt")); err != nil {
+		t.Fatalf("Failed to quarantine: %v", err)
+	}
+	path := filepath.Join(dir, ".gosafe", "quarantine", "etc", "passwd")
+	if data, err := os.ReadFile(path); err != nil || string(data) != "corrupt" {
+		t.Errorf("Expected the file to be quarantined in %s, got %v", path, err)
+	}
+	if rel, _ := filepath.Rel(dir, path); !repository.Reserved(filepath.ToSlash(rel)) {
+		t.Errorf("Expected %s to be reserved", rel)
+	}
+
+	config.Restore.QuarantineDir = filepath.Join(t.TempDir(), "elsewhere")
+	if err := quarantine(dir, "etc/passwd", []byte("corrupt")); err != nil {
+		t.Fatalf("Failed to quarantine: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(config.Restore.QuarantineDir, "etc", "passwd")); err != nil {
+		t.Errorf("Expected the file to be quarantined in --restore.quarantine-dir, got %v", err)
+	}
+}
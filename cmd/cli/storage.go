@@ -3,25 +3,34 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/yyewolf/go-safe/compression"
 	"github.com/yyewolf/go-safe/encryption"
 	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
 )
 
-func storageBackend(encryptionBackend encryption.EncryptionBackend) storage.StorageBackend {
+func storageBackend(encryptionBackend encryption.EncryptionBackend) *transform.Pipeline {
 	if config.S3.AccessID != "" {
-		return s3Backend(encryptionBackend)
+		return pipeline(s3Backend(), encryptionBackend)
 	}
 	return nil
 }
 
-func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBackend {
+// s3Backend returns the S3 backend of the namespace of the host restored from.
+func s3Backend() storage.StorageBackend {
+	return s3BackendAt(filepath.Join(config.S3.Dir, namespace))
+}
+
+// s3BackendAt returns an S3 backend storing under the directory.
+func s3BackendAt(dir string) storage.StorageBackend {
 	// Configure S3 backend
 	s3Config := &storage.S3Config{
 		StorageClass: config.S3.StorageClass,
-		Prepend:      config.S3.Dir,
+		Prepend:      dir,
 		Bucket:       config.S3.BucketName,
 		Config: aws.NewConfig().
 			WithCredentials(
@@ -41,7 +50,7 @@ func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBa
 		s3Config.Config = s3Config.Config.WithEndpoint(config.S3.Endpoint)
 	}
 
-	s3Backend, err := storage.NewS3Backend(s3Config, encryptionBackend)
+	s3Backend, err := storage.NewS3Backend(s3Config)
 	if err != nil {
 		fmt.Printf("Failed to configure S3 backend: %v\n", err)
 		os.Exit(1)
@@ -49,3 +58,76 @@ func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBa
 
 	return s3Backend
 }
+
+// pipeline wraps the storage backend with the stages able to restore objects.
+func pipeline(b storage.StorageBackend, encryptionBackend encryption.EncryptionBackend) *transform.Pipeline {
+	// The compression algorithm is read from each object
+	compressor, err := compression.NewCompressor(compression.AlgorithmNone, 0)
+	if err != nil {
+		fmt.Printf("Failed to configure compression: %v\n", err)
+		os.Exit(1)
+	}
+
+	transformers := []transform.Transformer{transform.NewCompressTransformer(compressor)}
+
+	// The padding scheme is read from the envelope of each object
+	for _, scheme := range []transform.PaddingScheme{transform.PaddingPadme, transform.PaddingPowerOfTwo} {
+		padTransformer, err := transform.NewPadTransformer(scheme)
+		if err != nil {
+			fmt.Printf("Failed to configure padding: %v\n", err)
+			os.Exit(1)
+		}
+		transformers = append(transformers, padTransformer)
+	}
+
+	transformers = append(transformers, transform.NewEncryptTransformer(encryptionBackend))
+
+	if config.Sign.PublicKeyLocation != "" {
+		transformers = append(transformers, verifyTransformer())
+	}
+
+	p, err := transform.NewPipeline(b, repositoryID, transformers...)
+	if err != nil {
+		fmt.Printf("Failed to configure transform pipeline: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Anyone able to encrypt could have forged unsigned objects
+	if config.Sign.PublicKeyLocation != "" {
+		if config.Sign.AllowUnsigned {
+			fmt.Println("Warning: unsigned objects will be restored")
+		} else {
+			p.Require("sign")
+		}
+	}
+
+	// Objects stored before envelopes could have been copied over any other one
+	if config.AllowLegacy {
+		fmt.Println("Warning: objects stored before envelopes will be restored")
+		p.AllowLegacy()
+	}
+
+	return p
+}
+
+func verifyTransformer() transform.Transformer {
+	data, err := os.ReadFile(config.Sign.PublicKeyLocation)
+	if err != nil {
+		fmt.Printf("Failed to read signing public key file: %v\n", err)
+		os.Exit(1)
+	}
+
+	publicKey, err := transform.ParseSigningPublicKey(data)
+	if err != nil {
+		fmt.Printf("Failed to parse signing public key: %v\n", err)
+		os.Exit(1)
+	}
+
+	verifyTransformer, err := transform.NewVerifyTransformer(publicKey)
+	if err != nil {
+		fmt.Printf("Failed to configure signature verification: %v\n", err)
+		os.Exit(1)
+	}
+
+	return verifyTransformer
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/lock"
+)
+
+var unlockForce bool
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove the stale locks of the repository, or every lock with --force",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Locks are stored directly in S3, no decryption key is needed
+		openRepository()
+		raw := s3Backend()
+
+		locks, err := lock.List(raw)
+		if err != nil {
+			fmt.Printf("Failed to list locks: %v\n", err)
+			os.Exit(1)
+		}
+
+		now := time.Now()
+		var removed, alive int
+		for _, l := range locks {
+			description := fmt.Sprintf("%s on %s (owner %s, pid %d), last heartbeat %s",
+				l.ID, l.Hostname, l.Owner, l.PID, l.Heartbeat.Local().Format(time.RFC3339))
+
+			// A live lock may belong to a daemon in the middle of a cycle
+			if !l.Expired(now) && !unlockForce {
+				fmt.Printf("Alive: %s\n", description)
+				alive++
+				continue
+			}
+
+			if err := raw.Delete(l.Key()); err != nil {
+				fmt.Printf("Failed to remove lock %s: %v\n", l.ID, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Removed: %s\n", description)
+			removed++
+		}
+
+		fmt.Printf("Removed %d locks\n", removed)
+		if alive > 0 {
+			fmt.Printf("%d locks are still alive, make sure their owner is gone and use --force to remove them\n", alive)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	unlockCmd.Flags().BoolVar(&unlockForce, "force", false, "Also remove the locks which did not expire")
+	rootCmd.AddCommand(unlockCmd)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/yyewolf/go-safe/merkle"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [root-hash]",
+	Short: "Prove the completeness and integrity of the whole snapshot from its root hash",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		b := openStorage()
+		loadDatabase(b)
+
+		// Download the manifest of the snapshot, bound to the same version as the index
+		data, err := b.Retrieve(manifestKey, databaseVersion)
+		if err != nil {
+			fmt.Printf("Failed to download %s from S3: %v\n", manifestKey, err)
+			os.Exit(1)
+		}
+
+		var manifest merkle.Node
+		err = json.Unmarshal(data, &manifest)
+		if err != nil {
+			fmt.Printf("Failed to unmarshal %s: %v\n", manifestKey, err)
+			os.Exit(1)
+		}
+
+		// Without a root hash recorded elsewhere, only the consistency can be checked
+		root := manifest.Hash
+		if len(args) == 1 {
+			root = args[0]
+		} else {
+			fmt.Println("Warning: no root hash given, trusting the one of the manifest")
+		}
+
+		err = manifest.Verify(root)
+		if err != nil {
+			fmt.Printf("Failed to verify manifest: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The index must describe the very same snapshot
+		files := make(map[string]string)
+		for path, file := range database {
+			files[path] = file.Sum
+		}
+		tree, err := merkle.Build(files)
+		if err != nil || tree.Hash != root {
+			fmt.Printf("Failed to verify %s: it does not match the manifest\n", databaseKey)
+			os.Exit(1)
+		}
+
+		// Every file of the snapshot must be stored and intact
+		paths := make([]string, 0, len(database))
+		for path := range database {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		failed := 0
+		for _, path := range paths {
+			file := database[path]
+			data, err := b.Retrieve(file.objectKey(path), file.Version)
+			if err != nil {
+				fmt.Printf("Failed to download %s: %v\n", path, err)
+				failed++
+				continue
+			}
+
+			hash := sha256.Sum256(data)
+			if hex.EncodeToString(hash[:]) != file.Sum {
+				fmt.Printf("Failed to verify hash of %s\n", path)
+				failed++
+			}
+		}
+
+		if failed > 0 {
+			fmt.Printf("Snapshot %s is damaged: %d of %d files failed verification\n", root, failed, len(paths))
+			os.Exit(1)
+		}
+
+		fmt.Printf("Snapshot %s verified: %d files\n", root, len(paths))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yyewolf/go-safe/anomaly"
+)
+
+// Alert is an anomaly which paused the uploads, until an operator
+// acknowledges it. It is kept in the backup directory, next to the database.
+type Alert struct {
+	Host         string   `json:"host"`
+	Time         int64    `json:"time"`
+	Reasons      []string `json:"reasons"`
+	Acknowledged bool     `json:"acknowledged"`
+}
+
+func detector() *anomaly.Detector {
+	detector, err := anomaly.NewDetector(anomaly.Thresholds{
+		Changed:  config.Anomaly.Changed,
+		Entropy:  config.Anomaly.Entropy,
+		Renamed:  config.Anomaly.Renamed,
+		MinFiles: config.Anomaly.MinFiles,
+	})
+	if err != nil {
+		fmt.Printf("Failed to configure anomaly detection: %v\n", err)
+		os.Exit(1)
+	}
+
+	return detector
+}
+
+func alertFile() string {
+	return filepath.Join(config.Backup.Dir, "alert.gosafe")
+}
+
+// loadAlert loads the pending alert, or returns nil if there is none.
+func loadAlert() (*Alert, error) {
+	data, err := os.ReadFile(alertFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alert := &Alert{}
+	if err := json.Unmarshal(data, alert); err != nil {
+		return nil, err
+	}
+
+	return alert, nil
+}
+
+func saveAlert(alert *Alert) error {
+	data, err := json.MarshalIndent(alert, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(alertFile(), data, 0600)
+}
+
+// raiseAlert pauses the uploads and notifies the operator.
+func raiseAlert(reasons []string) {
+	alert := &Alert{
+		Host:    host,
+		Time:    time.Now().Unix(),
+		Reasons: reasons,
+	}
+
+	fmt.Println("Anomaly detected, pausing uploads until acknowledged (go-safe --acknowledge):")
+	for _, reason := range reasons {
+		fmt.Println("  -", reason)
+	}
+
+	if err := saveAlert(alert); err != nil {
+		fmt.Printf("Failed to save alert: %v\n", err)
+	}
+
+	if err := notify(alert); err != nil {
+		fmt.Printf("Failed to send alert: %v\n", err)
+	}
+}
+
+// notify posts the alert as JSON to the webhook, if one is configured.
+func notify(alert *Alert) error {
+	if config.Anomaly.Webhook == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(config.Anomaly.Webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// acknowledge lets the daemon upload the changes which raised the alert.
+func acknowledge() {
+	alert, err := loadAlert()
+	if err != nil {
+		fmt.Printf("Failed to load alert: %v\n", err)
+		os.Exit(1)
+	}
+	if alert == nil {
+		fmt.Println("No pending alert")
+		return
+	}
+
+	alert.Acknowledged = true
+	if err := saveAlert(alert); err != nil {
+		fmt.Printf("Failed to save alert: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Alert acknowledged, uploads will resume at the next cycle")
+}
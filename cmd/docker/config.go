@@ -3,10 +3,12 @@ package main
 import (
 	"os"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/yyewolf/go-safe/lock"
 )
 
 type Config struct {
@@ -20,6 +22,14 @@ type Config struct {
 		StorageClass string `mapstructure:"storage-class"`
 	} `mapstructure:"s3"`
 
+	Repository struct {
+		ID string `mapstructure:"id"`
+	} `mapstructure:"repository"`
+
+	Host struct {
+		ID string `mapstructure:"id"`
+	} `mapstructure:"host"`
+
 	Backup struct {
 		Dir string `mapstructure:"dir"`
 	} `mapstructure:"backup"`
@@ -39,11 +49,65 @@ type Config struct {
 
 		PresharedKey   string `mapstructure:"preshared-key"`
 		PresharedKeyID string `mapstructure:"preshared-key-id"`
+
+		KEM  string `mapstructure:"kem"`
+		KDF  string `mapstructure:"kdf"`
+		AEAD string `mapstructure:"aead"`
+		Mode string `mapstructure:"mode"`
 	} `mapstructure:"hpke"`
 
+	Age struct {
+		RecipientsLocation string `mapstructure:"recipients-location"`
+		Passphrase         string `mapstructure:"passphrase"`
+	} `mapstructure:"age"`
+
+	OpenPGP struct {
+		PublicKeyLocation string `mapstructure:"public-key-location"`
+	} `mapstructure:"openpgp"`
+
+	Compression struct {
+		Algorithm string `mapstructure:"algorithm"`
+		Level     int    `mapstructure:"level"`
+	} `mapstructure:"compression"`
+
+	Sign struct {
+		PrivateKeyLocation string `mapstructure:"private-key-location"`
+	} `mapstructure:"sign"`
+
+	Anomaly struct {
+		Changed  float64 `mapstructure:"changed"`
+		Entropy  float64 `mapstructure:"entropy"`
+		Renamed  float64 `mapstructure:"renamed"`
+		MinFiles int     `mapstructure:"min-files"`
+		Webhook  string  `mapstructure:"webhook"`
+	} `mapstructure:"anomaly"`
+
+	Lock struct {
+		TTL time.Duration `mapstructure:"ttl"`
+	} `mapstructure:"lock"`
+
+	Index struct {
+		Keep int `mapstructure:"keep"`
+	} `mapstructure:"index"`
+
+	Padding string `mapstructure:"padding"`
+
+	Naming struct {
+		Scheme      string `mapstructure:"scheme"`
+		KeyLocation string `mapstructure:"key-location"`
+	} `mapstructure:"naming"`
+
 	Interval int  `mapstructure:"interval"`
 	Export   bool `mapstructure:"export"`
 	Sync     bool `mapstructure:"sync"`
+	DryRun   bool `mapstructure:"dry-run"`
+
+	AppendOnly bool `mapstructure:"append-only"`
+
+	Acknowledge bool `mapstructure:"acknowledge"`
+
+	GracePeriod     time.Duration `mapstructure:"grace-period"`
+	DeleteThreshold float64       `mapstructure:"delete-threshold"`
 }
 
 var config Config
@@ -64,6 +128,10 @@ func init() {
 
 	rootCmd.MarkFlagsRequiredTogether("s3.access-id", "s3.access-key", "s3.bucket-name", "s3.endpoint", "s3.region")
 
+	// Repository Related
+	rootCmd.Flags().String("repository.id", "", "Repository ID, bound to every stored object (must match between backup and restore), generated for new repositories if empty")
+	rootCmd.Flags().String("host.id", "", "Identity of this host, naming its namespace in the repository (defaults to the hostname, recorded in the backup directory)")
+
 	// AES Related
 	rootCmd.Flags().String("aes.key-location", "", "AES key location")
 
@@ -76,15 +144,58 @@ func init() {
 	rootCmd.Flags().String("hpke.server-public-key-location", "", "HPKE server public key location")
 	rootCmd.Flags().String("hpke.preshared-key", "", "HPKE preshared key")
 	rootCmd.Flags().String("hpke.preshared-key-id", "", "HPKE preshared key ID")
+	rootCmd.Flags().String("hpke.kem", "x25519", "HPKE KEM (x25519, x448, p256, p384, p521)")
+	rootCmd.Flags().String("hpke.kdf", "sha256", "HPKE KDF (sha256, sha384, sha512)")
+	rootCmd.Flags().String("hpke.aead", "chacha20poly1305", "HPKE AEAD (chacha20poly1305, aes128gcm, aes256gcm)")
+	rootCmd.Flags().String("hpke.mode", "", "HPKE mode (base, psk, auth, auth-psk), deduced from the keys if empty")
+
+	// Age Related
+	rootCmd.Flags().String("age.recipients-location", "", "Age recipients file location (X25519 or SSH public keys)")
+	rootCmd.Flags().String("age.passphrase", "", "Age passphrase (scrypt)")
+
+	// OpenPGP Related
+	rootCmd.Flags().String("openpgp.public-key-location", "", "OpenPGP armored public key file or keyring directory location")
 
 	// Encryption related
-	rootCmd.MarkFlagsMutuallyExclusive("aes.key-location", "ecies.public-key-location", "hpke.client-secret-key-location")
+	rootCmd.MarkFlagsMutuallyExclusive("aes.key-location", "ecies.public-key-location", "hpke.server-public-key-location", "age.recipients-location", "age.passphrase", "openpgp.public-key-location")
+
+	// Compression Related
+	rootCmd.Flags().String("compression.algorithm", "zstd", "Compression applied before encryption (none, gzip, zstd)")
+	rootCmd.Flags().Int("compression.level", 0, "Compression level (1-9 for gzip, 1-22 for zstd), 0 for the default level")
+
+	// Signing Related
+	rootCmd.Flags().String("sign.private-key-location", "", "Ed25519 signing private key location (PEM)")
+
+	// Padding Related
+	rootCmd.Flags().String("padding", "none", "Padding hiding the size of objects (none, padme, pow2)")
+
+	// Naming Related
+	rootCmd.Flags().String("naming.scheme", "plain", "Object naming scheme (plain, hmac, random)")
+	rootCmd.Flags().String("naming.key-location", "", "HMAC naming key location (at least 32 bytes)")
+
+	// Lock Related
+	rootCmd.Flags().Duration("lock.ttl", lock.DefaultTTL, "How long the lock of the repository stays valid without a heartbeat")
+
+	// Index Related
+	rootCmd.Flags().Int("index.keep", 10, "Number of generations of the index kept in S3, 0 to keep them all")
+
+	// Anomaly Related
+	rootCmd.Flags().Float64("anomaly.changed", 50, "Pause uploads if more than this percentage of the files are modified at once, 0 to disable")
+	rootCmd.Flags().Float64("anomaly.entropy", 20, "Pause uploads if more than this percentage of the files start looking encrypted at once, 0 to disable")
+	rootCmd.Flags().Float64("anomaly.renamed", 20, "Pause uploads if more than this percentage of the files get a new extension at once, 0 to disable")
+	rootCmd.Flags().Int("anomaly.min-files", 10, "Number of files a check needs to pause uploads")
+	rootCmd.Flags().String("anomaly.webhook", "", "URL the alert is posted to as JSON when uploads are paused")
+	rootCmd.Flags().Bool("acknowledge", false, "Acknowledge the pending alert so that uploads resume, then exit")
 
 	// Misc
 	rootCmd.Flags().String("backup.dir", "", "Backup directory")
 	rootCmd.Flags().Int("interval", 60, "Backup interval in seconds")
 	rootCmd.Flags().Bool("export", false, "Export the config file to stdout")
 	rootCmd.Flags().Bool("sync", false, "Sync the backup directory to S3 (delete local will delete remote)")
+	rootCmd.Flags().Duration("grace-period", 7*24*time.Hour, "How long a file deleted locally is kept in S3 before it is deleted with --sync")
+	rootCmd.Flags().Float64("delete-threshold", 50, "Abort the deletion pass if more than this percentage of the files disappeared at once")
+	rootCmd.Flags().Bool("append-only", false, "Never delete nor overwrite objects in S3, every change gets a new key (prune from elsewhere)")
+	rootCmd.Flags().Bool("dry-run", false, "Print what would be uploaded and deleted, then exit without changing anything")
 
 	// rootCmd.SetGlobalNormalizationFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
 	// 	replacer := strings.NewReplacer("-", "_", ".", "_")
@@ -106,6 +217,22 @@ func initConfig() {
 	viper.SetDefault("interval", 60)
 	viper.SetDefault("export", false)
 	viper.SetDefault("sync", false)
+	viper.SetDefault("dry-run", false)
+	viper.SetDefault("append-only", false)
+	viper.SetDefault("grace-period", 7*24*time.Hour)
+	viper.SetDefault("delete-threshold", 50)
+	viper.SetDefault("hpke.kem", "x25519")
+	viper.SetDefault("hpke.kdf", "sha256")
+	viper.SetDefault("hpke.aead", "chacha20poly1305")
+	viper.SetDefault("compression.algorithm", "zstd")
+	viper.SetDefault("padding", "none")
+	viper.SetDefault("naming.scheme", "plain")
+	viper.SetDefault("lock.ttl", lock.DefaultTTL)
+	viper.SetDefault("index.keep", 10)
+	viper.SetDefault("anomaly.changed", 50)
+	viper.SetDefault("anomaly.entropy", 20)
+	viper.SetDefault("anomaly.renamed", 20)
+	viper.SetDefault("anomaly.min-files", 10)
 
 	viper.AutomaticEnv()
 
@@ -1,19 +1,52 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"os"
 )
 
 var databaseFile string
 
+// snapshotFormat formats the time of the snapshots stored under
+// .gosafe/index/, so that their keys sort chronologically.
+const snapshotFormat = "20060102T150405.000000000Z"
+
 type File struct {
-	Sum string `json:"s"`
+	Sum     string `json:"s"`
+	Version uint64 `json:"v,omitempty"`
+	// Key is the key of the object, when it isn't the path of the file
+	Key string `json:"k,omitempty"`
+	// Size, ModTime (in Unix seconds) and Mode of the file when it was backed up
+	Size    int64       `json:"z,omitempty"`
+	ModTime int64       `json:"m,omitempty"`
+	Mode    os.FileMode `json:"p,omitempty"`
+	// Entropy of the content (in bits per byte), to notice files turning into
+	// what looks like encrypted data
+	Entropy float64 `json:"e,omitempty"`
+	// Deleted is when the file was first found missing (in Unix seconds), its
+	// object is only deleted once the grace period is over
+	Deleted int64 `json:"d,omitempty"`
+}
+
+// setMetadata records the metadata of the file.
+func (f *File) setMetadata(info os.FileInfo) {
+	f.Size = info.Size()
+	f.ModTime = info.ModTime().Unix()
+	f.Mode = info.Mode().Perm()
+}
+
+// objectKey returns the key of the object storing the file at path.
+func (f *File) objectKey(path string) string {
+	if f.Key != "" {
+		return f.Key
+	}
+	return path
 }
 
 var database map[string]*File
+
+// databaseDigest is the digest of the last uploaded database. It starts
+// empty, so that the index is uploaded at startup, e.g. once recovered.
 var databaseDigest string
 
 func loadDatabase(f string) {
@@ -26,9 +59,6 @@ func loadDatabase(f string) {
 		return
 	}
 
-	sum := sha256.Sum256(data)
-	databaseDigest = hex.EncodeToString(sum[:])
-
 	json.Unmarshal(data, &database)
 }
 
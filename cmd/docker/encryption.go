@@ -3,8 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/yyewolf/go-safe/encryption"
+	"github.com/yyewolf/go-safe/repository"
 )
 
 func encryptionBackend() encryption.EncryptionBackend {
@@ -16,13 +19,50 @@ func encryptionBackend() encryption.EncryptionBackend {
 		return eciesPublicEncryptionBackend()
 	}
 
-	if config.HPKE.ClientPublicKeyLocation != "" && config.HPKE.ClientSecretKeyLocation != "" && config.HPKE.ServerPublicKeyLocation != "" {
+	if config.HPKE.ServerPublicKeyLocation != "" {
 		return hpkeEncryptionBackend()
 	}
 
+	if config.Age.RecipientsLocation != "" || config.Age.Passphrase != "" {
+		return ageEncryptionBackend()
+	}
+
+	if config.OpenPGP.PublicKeyLocation != "" {
+		return openPGPEncryptionBackend()
+	}
+
 	return nil
 }
 
+// encryptionConfig describes the configured encryption backend for the
+// repository config, leaving out anything secret.
+func encryptionConfig() repository.Encryption {
+	switch {
+	case config.AES.KeyLocation != "":
+		return repository.Encryption{Backend: "aes"}
+	case config.ECIES.PublicKeyLocation != "":
+		return repository.Encryption{Backend: "ecies"}
+	case config.HPKE.ServerPublicKeyLocation != "":
+		parameters := map[string]string{
+			"kem":  config.HPKE.KEM,
+			"kdf":  config.HPKE.KDF,
+			"aead": config.HPKE.AEAD,
+		}
+		if config.HPKE.Mode != "" {
+			parameters["mode"] = config.HPKE.Mode
+		}
+		return repository.Encryption{Backend: "hpke", Parameters: parameters}
+	case config.Age.RecipientsLocation != "":
+		return repository.Encryption{Backend: "age", Parameters: map[string]string{"mode": "recipients"}}
+	case config.Age.Passphrase != "":
+		return repository.Encryption{Backend: "age", Parameters: map[string]string{"mode": "passphrase"}}
+	case config.OpenPGP.PublicKeyLocation != "":
+		return repository.Encryption{Backend: "openpgp"}
+	}
+
+	return repository.Encryption{}
+}
+
 func aesEncryptionBackend() encryption.EncryptionBackend {
 	// Check key file permissions and existence
 	st, err := os.Stat(config.AES.KeyLocation)
@@ -86,57 +126,149 @@ func eciesPublicEncryptionBackend() encryption.EncryptionBackend {
 }
 
 func hpkeEncryptionBackend() encryption.EncryptionBackend {
+	kemID, kdfID, aeadID, err := encryption.ParseHPKESuite(config.HPKE.KEM, config.HPKE.KDF, config.HPKE.AEAD)
+	if err != nil {
+		fmt.Printf("Failed to configure encryption backend: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Read the key files, the client secret key is only needed in auth modes
+	serverPublicKey := readHPKEKey(config.HPKE.ServerPublicKeyLocation, "server public key")
+	clientSecretKey := readHPKEKey(config.HPKE.ClientSecretKeyLocation, "client secret key")
+
+	// Configure encryption backend
+	encryptionBackend := &encryption.HPKEBackend{}
+	err = encryptionBackend.Initialize(&encryption.HPKEConfig{
+		UtilitySKey:    clientSecretKey,
+		YourPKey:       serverPublicKey,
+		PresharedKey:   []byte(config.HPKE.PresharedKey),
+		PresharedKeyID: []byte(config.HPKE.PresharedKeyID),
+		KEM:            kemID,
+		KDF:            kdfID,
+		AEAD:           aeadID,
+		Mode:           encryption.HPKEMode(config.HPKE.Mode),
+	})
+	if err != nil {
+		fmt.Printf("Failed to configure encryption backend: %v\n", err)
+		os.Exit(1)
+	}
+
+	return encryptionBackend
+}
+
+func readHPKEKey(location string, name string) []byte {
+	if location == "" {
+		return nil
+	}
+
 	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ClientPublicKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+	if st, err := os.Stat(location); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
 		if err != nil {
-			fmt.Printf("Failed to stat client public key file: %v\n", err)
+			fmt.Printf("Failed to stat %s file: %v\n", name, err)
 			os.Exit(1)
 		}
-		fmt.Println("Client public key file permissions are too open")
+		fmt.Printf("%s file permissions are too open\n", strings.ToUpper(name[:1])+name[1:])
 		os.Exit(1)
 	}
 
-	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ClientSecretKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+	// Read the key file
+	key, err := os.ReadFile(location)
+	if err != nil {
+		fmt.Printf("Failed to read %s file: %v\n", name, err)
+		os.Exit(1)
+	}
+
+	return key
+}
+
+func ageEncryptionBackend() encryption.EncryptionBackend {
+	var recipients []byte
+	if config.Age.RecipientsLocation != "" {
+		// Check key file permissions and existence
+		st, err := os.Stat(config.Age.RecipientsLocation)
 		if err != nil {
-			fmt.Printf("Failed to stat client secret key file: %v\n", err)
+			fmt.Printf("Failed to stat recipients file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Client secret key file permissions are too open")
-		os.Exit(1)
-	}
 
-	// Check key file permissions and existence
-	if st, err := os.Stat(config.HPKE.ServerPublicKeyLocation); err != nil || st.Mode() != 0600 && st.Mode() != 0400 {
+		// Key should only be readable by the owner
+		if st.Mode() != 0600 && st.Mode() != 0400 {
+			fmt.Println("Recipients file permissions are too open")
+			os.Exit(1)
+		}
+
+		// Read the recipients file
+		recipients, err = os.ReadFile(config.Age.RecipientsLocation)
 		if err != nil {
-			fmt.Printf("Failed to stat server public key file: %v\n", err)
+			fmt.Printf("Failed to read recipients file: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Server public key file permissions are too open")
-		os.Exit(1)
 	}
 
-	// Read the key files
-	clientPublicKey, err := os.ReadFile(config.HPKE.ClientPublicKeyLocation)
+	// Configure encryption backend
+	encryptionBackend, err := encryption.NewAgeEncryptionBackend(string(recipients), "", config.Age.Passphrase)
 	if err != nil {
-		fmt.Printf("Failed to read client public key file: %v\n", err)
+		fmt.Printf("Failed to configure encryption backend: %v\n", err)
 		os.Exit(1)
 	}
 
-	clientSecretKey, err := os.ReadFile(config.HPKE.ClientSecretKeyLocation)
+	return encryptionBackend
+}
+
+func openPGPEncryptionBackend() encryption.EncryptionBackend {
+	// Check key location existence
+	st, err := os.Stat(config.OpenPGP.PublicKeyLocation)
 	if err != nil {
-		fmt.Printf("Failed to read client secret key file: %v\n", err)
+		fmt.Printf("Failed to stat public key location: %v\n", err)
 		os.Exit(1)
 	}
 
-	serverPublicKey, err := os.ReadFile(config.HPKE.ServerPublicKeyLocation)
-	if err != nil {
-		fmt.Printf("Failed to read server public key file: %v\n", err)
+	// A directory is treated as a keyring, every file in it being a public key
+	keyFiles := []string{config.OpenPGP.PublicKeyLocation}
+	if st.IsDir() {
+		entries, err := os.ReadDir(config.OpenPGP.PublicKeyLocation)
+		if err != nil {
+			fmt.Printf("Failed to read keyring directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		keyFiles = nil
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				keyFiles = append(keyFiles, filepath.Join(config.OpenPGP.PublicKeyLocation, entry.Name()))
+			}
+		}
+	}
+
+	var publicKeys []string
+	for _, keyFile := range keyFiles {
+		// Key should only be readable by the owner
+		st, err := os.Stat(keyFile)
+		if err != nil {
+			fmt.Printf("Failed to stat public key file: %v\n", err)
+			os.Exit(1)
+		}
+		if st.Mode() != 0600 && st.Mode() != 0400 {
+			fmt.Printf("Public key file %s permissions are too open\n", keyFile)
+			os.Exit(1)
+		}
+
+		// Read the key file
+		publicKey, err := os.ReadFile(keyFile)
+		if err != nil {
+			fmt.Printf("Failed to read public key file: %v\n", err)
+			os.Exit(1)
+		}
+		publicKeys = append(publicKeys, string(publicKey))
+	}
+
+	if len(publicKeys) == 0 {
+		fmt.Println("No OpenPGP public key found")
 		os.Exit(1)
 	}
 
 	// Configure encryption backend
-	encryptionBackend, err := encryption.NewHPKEBackend(clientPublicKey, clientSecretKey, serverPublicKey, nil, []byte(config.HPKE.PresharedKey), []byte(config.HPKE.PresharedKeyID))
+	encryptionBackend, err := encryption.NewOpenPGPEncryptionBackend(publicKeys, "", nil)
 	if err != nil {
 		fmt.Printf("Failed to configure encryption backend: %v\n", err)
 		os.Exit(1)
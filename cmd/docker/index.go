@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yyewolf/go-safe/repository"
+	"sort"
+	"time"
+
+	"github.com/yyewolf/go-safe/transform"
+)
+
+// indexVersion is the version of the latest generation of the index. Every
+// generation is bound to the next one, so that an older generation can't be
+// passed off as a newer one.
+var indexVersion uint64
+
+// loadIndexVersion reads the version of the latest generation of the index
+// from its envelope, which the daemon can read without decrypting it.
+func loadIndexVersion() error {
+	raw := s3Backend()
+	objects, err := raw.List(repository.IndexPrefix)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	// Generation keys sort chronologically
+	latest := objects[0].Key
+	for _, object := range objects {
+		if object.Key > latest {
+			latest = object.Key
+		}
+	}
+
+	data, err := raw.Retrieve(latest, 0)
+	if err != nil {
+		return err
+	}
+
+	// Generations from before versions were recorded are version 0
+	version, err := transform.Version(data)
+	if err != nil && !errors.Is(err, transform.ErrNoVersion) {
+		return err
+	}
+	indexVersion = version
+
+	return nil
+}
+
+// uploadIndex uploads a new generation of the index along with its manifest,
+// under .gosafe/index/ and .gosafe/manifest/. Only once both are stored,
+// head.gosafe is moved to point at it, and the generations beyond the ones to
+// keep are deleted.
+// In append-only mode, nothing is overwritten nor deleted: the latest
+// generation is found by listing them.
+func uploadIndex(b *transform.Pipeline, data []byte) error {
+	stamp := time.Now().UTC().Format(snapshotFormat)
+	databaseKey := repository.IndexPrefix + stamp + ".gosafe"
+	manifestKey := repository.ManifestKey(databaseKey)
+	version := indexVersion + 1
+
+	fmt.Println("Uploading manifest...")
+	if err := uploadManifest(b, manifestKey, version); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+
+	fmt.Println("Uploading database...")
+	if err := b.Store(databaseKey, version, data); err != nil {
+		return err
+	}
+	indexVersion = version
+
+	if config.AppendOnly {
+		return nil
+	}
+
+	if err := b.Store(repository.HeadKey, version, []byte(databaseKey)); err != nil {
+		return fmt.Errorf("head: %v", err)
+	}
+
+	return pruneGenerations(b)
+}
+
+// pruneGenerations deletes the oldest generations of the index, keeping
+// the configured number of them.
+func pruneGenerations(b *transform.Pipeline) error {
+	if config.Index.Keep <= 0 {
+		return nil
+	}
+
+	objects, err := b.List(repository.IndexPrefix)
+	if err != nil {
+		return err
+	}
+
+	generations := make([]string, 0, len(objects))
+	for _, object := range objects {
+		generations = append(generations, object.Key)
+	}
+	sort.Strings(generations)
+
+	for len(generations) > config.Index.Keep {
+		key := generations[0]
+		generations = generations[1:]
+
+		if err := b.Delete(repository.ManifestKey(key)); err != nil {
+			return err
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
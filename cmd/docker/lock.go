@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/yyewolf/go-safe/lock"
+)
+
+// held is the lock taken for the current cycle, released if the daemon is
+// stopped in the middle of it.
+var held struct {
+	sync.Mutex
+	lock *lock.Lock
+	stop func()
+}
+
+// locker takes the locks of the repository. Locks are stored directly in S3,
+// bypassing the pipeline and the append-only mode, so that the retriever can
+// read them and the daemon can refresh and release them.
+func locker() *lock.Locker {
+	locker, err := lock.NewLocker(s3Backend(), config.Lock.TTL)
+	if err != nil {
+		fmt.Printf("Failed to configure locking: %v\n", err)
+		os.Exit(1)
+	}
+
+	return locker
+}
+
+// lockRepository takes an exclusive lock for the writes of a cycle, and keeps
+// it alive until unlockRepository is called.
+func lockRepository(l *lock.Locker) error {
+	held.Lock()
+	defer held.Unlock()
+
+	taken, err := l.Lock(true)
+	if err != nil {
+		return err
+	}
+
+	held.lock = taken
+	held.stop = l.KeepAlive(taken, func(err error) {
+		fmt.Printf("Failed to refresh lock: %v\n", err)
+	})
+
+	return nil
+}
+
+// unlockRepository releases the lock of the cycle, if any.
+func unlockRepository(l *lock.Locker) {
+	held.Lock()
+	defer held.Unlock()
+
+	if held.lock == nil {
+		return
+	}
+
+	held.stop()
+	if err := l.Unlock(held.lock); err != nil {
+		fmt.Printf("Failed to unlock repository: %v\n", err)
+	}
+	held.lock = nil
+}
+
+// releaseOnExit releases the lock when the daemon is stopped, so that other
+// writers don't have to wait for it to expire.
+func releaseOnExit(l *lock.Locker) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		fmt.Println("Stopping, releasing lock...")
+		unlockRepository(l)
+		os.Exit(1)
+	}()
+}
@@ -4,13 +4,15 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/lock"
+	"github.com/yyewolf/go-safe/naming"
+	"github.com/yyewolf/go-safe/transform"
 )
 
 // Create and configure the Cobra command
@@ -30,6 +32,21 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Check that backup directory exists and is a directory
+		if st, err := os.Stat(config.Backup.Dir); err != nil || !st.IsDir() {
+			fmt.Println("Backup directory does not exist or is not a directory")
+			os.Exit(1)
+		}
+
+		// Let the daemon upload the changes which paused it
+		if config.Acknowledge {
+			acknowledge()
+			os.Exit(0)
+		}
+
+		// Find the namespace of this host in the repository
+		openRepository()
+
 		// Configure storage backend
 		s3Backend := storageBackend(encryptionBackend)
 		if s3Backend == nil {
@@ -37,18 +54,31 @@ var rootCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// Check that backup directory exists and is a directory
-		if st, err := os.Stat(config.Backup.Dir); err != nil || !st.IsDir() {
-			fmt.Println("Backup directory does not exist or is not a directory")
-			os.Exit(1)
-		}
-
 		dbFile := filepath.Join(config.Backup.Dir, "db.gosafe")
 
 		loadDatabase(dbFile)
 
+		// Only show what would be done, without uploading, deleting nor saving anything
+		if config.DryRun {
+			plan, err := scan()
+			if err != nil {
+				fmt.Printf("Failed to walk backup directory: %v\n", err)
+				os.Exit(1)
+			}
+			plan.print()
+			os.Exit(0)
+		}
+
 		fmt.Println("Starting backup service in '", config.Backup.Dir, "'...")
-		worker(s3Backend)
+		// Every generation of the index gets a higher version than the latest one
+		if err := loadIndexVersion(); err != nil {
+			fmt.Printf("Failed to read the version of the index: %v\n", err)
+			os.Exit(1)
+		}
+
+		l := locker()
+		releaseOnExit(l)
+		worker(s3Backend, namer(), l)
 	},
 }
 
@@ -60,142 +90,218 @@ func main() {
 	}
 }
 
-func worker(b storage.StorageBackend) {
+func worker(b *transform.Pipeline, n naming.Namer, l *lock.Locker) {
 	duration := time.Duration(config.Interval) * time.Second
 
 	for {
-		// Walk the backup directory and upload any new or modified files
-		err := filepath.Walk(config.Backup.Dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+		// A partial plan would skip the anomaly detection over the files it
+		// misses, and delete them with --sync
+		plan, err := scan()
+		if err != nil {
+			fmt.Printf("Failed to walk backup directory, skipping this cycle: %v\n", err)
+			time.Sleep(duration)
+			continue
+		}
 
-			// Skip if it's not a readable file
-			if !info.Mode().IsRegular() || info.Mode()&0400 == 0 {
-				return nil
+		// Don't overwrite the good copies with what may be encrypted by ransomware
+		alert, err := loadAlert()
+		switch {
+		case err != nil:
+			fmt.Printf("Failed to load alert, uploads stay paused: %v\n", err)
+			time.Sleep(duration)
+			continue
+		case alert != nil && !alert.Acknowledged:
+			fmt.Println("Uploads paused by an anomaly, waiting for acknowledgement (go-safe --acknowledge)")
+			time.Sleep(duration)
+			continue
+		case alert != nil:
+			fmt.Println("Alert acknowledged, resuming uploads...")
+			if err := os.Remove(alertFile()); err != nil {
+				fmt.Printf("Failed to remove alert: %v\n", err)
 			}
+		case len(plan.Anomalies) > 0:
+			raiseAlert(plan.Anomalies)
+			time.Sleep(duration)
+			continue
+		}
 
-			savePath := path
-			// Remove the backup directory from the path and add s3Dir if it's set
-			if strings.HasPrefix(path, config.Backup.Dir) {
-				savePath = path[len(config.Backup.Dir):]
-				if savePath[0] == filepath.Separator {
-					savePath = savePath[1:]
-				}
-			}
+		// Objects bound to another repository ID could never be restored
+		err = checkRepository()
+		if err != nil {
+			fmt.Printf("Refusing to back up into this repository, skipping this cycle: %v\n", err)
+			time.Sleep(duration)
+			continue
+		}
 
-			if savePath == "db.gosafe" {
-				return nil
-			}
+		// Don't write while another daemon or a prune is writing
+		err = lockRepository(l)
+		if err != nil {
+			fmt.Printf("Failed to lock repository, skipping this cycle: %v\n", err)
+			time.Sleep(duration)
+			continue
+		}
+
+		apply(b, n, plan)
+
+		// Save the database
+		err = saveDatabase()
+		if err != nil {
+			fmt.Printf("Failed to save database: %v\n", err)
+		}
 
-			// Read the file
-			data, err := os.ReadFile(path)
+		// Check if the database has been modified
+		data, err := os.ReadFile(filepath.Join(config.Backup.Dir, "db.gosafe"))
+		if err != nil {
+			fmt.Printf("Failed to read database: %v\n", err)
+		}
+
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+
+		if digest != databaseDigest {
+			// Database has been modified, so upload a new generation of it
+			err = uploadIndex(b, data)
 			if err != nil {
-				fmt.Printf("Failed to read %s: %v\n", path, err)
-				return nil
+				fmt.Printf("Failed to upload database: %v\n", err)
+			} else {
+				databaseDigest = digest
 			}
+		}
 
-			// Check if the file is already in the database
-			_, ok := database[savePath]
-			if !ok {
-				// File is not in the database, so upload it
-				fmt.Println("Uploading", path, "...")
+		unlockRepository(l)
 
-				err = b.Store(savePath, data)
-				if err != nil {
-					fmt.Printf("Failed to upload %s: %v\n", path, err)
-					return nil
-				}
+		time.Sleep(duration)
+	}
+}
 
-				// SHA256 sum the file
-				sum := sha256.Sum256(data)
-				digest := hex.EncodeToString(sum[:])
+// apply uploads and deletes the files of the plan, updating the database.
+func apply(b *transform.Pipeline, n naming.Namer, plan *Plan) {
+	for _, upload := range plan.Uploads {
+		// Read the file again, it may have changed since it was scanned
+		data, err := os.ReadFile(upload.Path)
+		if err != nil {
+			fmt.Printf("Failed to read %s: %v\n", upload.Path, err)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
 
-				// Add the file to the database
-				database[savePath] = &File{
-					Sum: digest,
-				}
+		// Embedded in the object, so that the index can be rebuilt from the bucket
+		metadata := &transform.Metadata{
+			Path:    upload.SavePath,
+			Sum:     digest,
+			Size:    upload.Info.Size(),
+			ModTime: upload.Info.ModTime().Unix(),
+			Mode:    upload.Info.Mode().Perm(),
+		}
+
+		file, ok := database[upload.SavePath]
+		if !ok {
+			// File is not in the database, so upload it
+			fmt.Println("Uploading", upload.Path, "...")
 
-				return nil
+			key, err := objectName(n, upload.SavePath, 1)
+			if err != nil {
+				fmt.Printf("Failed to name %s: %v\n", upload.Path, err)
+				continue
 			}
 
-			// File is in the database, so check if it has been modified
-			sum := sha256.Sum256(data)
-			digest := hex.EncodeToString(sum[:])
+			err = b.StoreFile(key, 1, data, metadata)
+			if err != nil {
+				fmt.Printf("Failed to upload %s: %v\n", upload.Path, err)
+				continue
+			}
 
-			if digest != database[savePath].Sum {
-				// File has been modified, so upload it
-				fmt.Println("Uploading", path, " (modified)...")
+			// Add the file to the database
+			file = &File{
+				Sum:     digest,
+				Version: 1,
+			}
+			if key != upload.SavePath {
+				file.Key = key
+			}
+			database[upload.SavePath] = file
+		} else {
+			// File has been modified, so upload it
+			if upload.Legacy {
+				fmt.Println("Uploading", upload.Path, " (stored before envelopes)...")
+			} else {
+				fmt.Println("Uploading", upload.Path, " (modified)...")
+			}
 
-				err = b.Store(savePath, data)
+			// Every upload gets a new version, so older ones can't be replayed
+			version := file.Version + 1
+			key := file.objectKey(upload.SavePath)
+			if config.AppendOnly {
+				key, err = objectName(n, upload.SavePath, version)
 				if err != nil {
-					fmt.Printf("Failed to upload %s: %v\n", path, err)
+					fmt.Printf("Failed to name %s: %v\n", upload.Path, err)
+					continue
 				}
-
-				database[savePath].Sum = digest
 			}
 
-			return nil
-		})
-
-		if err != nil {
-			fmt.Printf("Failed to walk backup directory: %v\n", err)
-		}
+			err = b.StoreFile(key, version, data, metadata)
+			if err != nil {
+				fmt.Printf("Failed to upload %s: %v\n", upload.Path, err)
+				continue
+			}
 
-		if config.Sync {
-			// Delete any files that have been deleted
-			for path := range database {
-				// Check if the file exists
-				path = filepath.Join(config.Backup.Dir, path)
-				_, err := os.Stat(path)
-				if err != nil {
-					// File does not exist, so delete it from the database
-					fmt.Println("Deleting", path, "...")
-
-					savePath := path
-					// Remove the backup directory from the path
-					if strings.HasPrefix(path, config.Backup.Dir) {
-						savePath = path[len(config.Backup.Dir):]
-						if savePath[0] == filepath.Separator {
-							savePath = savePath[1:]
-						}
-					}
-
-					err = b.Delete(savePath)
-					if err != nil {
-						fmt.Printf("Failed to delete %s: %v\n", path, err)
-					}
-
-					delete(database, path)
-				}
+			file.Sum = digest
+			file.Version = version
+			if key != upload.SavePath {
+				file.Key = key
 			}
 		}
 
-		// Save the database
-		err = saveDatabase()
-		if err != nil {
-			fmt.Printf("Failed to save database: %v\n", err)
+		file.setMetadata(upload.Info)
+		file.Entropy = math.Round(upload.Entropy*100) / 100
+		file.Deleted = 0
+	}
+
+	updateMetadata(plan)
+
+	if plan.Aborted != "" {
+		fmt.Printf("Deletion pass aborted: %s\n", plan.Aborted)
+	}
+
+	// Missing files are kept in S3 for the grace period, in case they come back
+	now := time.Now().Unix()
+	for _, path := range plan.Tombstones {
+		fmt.Println("Marking", path, "as deleted...")
+		database[path].Deleted = now
+	}
+
+	for _, path := range plan.Deletes {
+		// File has been missing for the whole grace period, so delete it
+		if config.AppendOnly {
+			// The object is left for the prune command, run from elsewhere
+			fmt.Println("Forgetting", path, "...")
+			delete(database, path)
+			continue
 		}
 
-		// Check if the database has been modified
-		data, err := os.ReadFile(filepath.Join(config.Backup.Dir, "db.gosafe"))
+		fmt.Println("Deleting", path, "...")
+
+		err := b.Delete(database[path].objectKey(path))
 		if err != nil {
-			fmt.Printf("Failed to read database: %v\n", err)
+			fmt.Printf("Failed to delete %s: %v\n", path, err)
+			continue
 		}
 
-		sum := sha256.Sum256(data)
-		digest := hex.EncodeToString(sum[:])
+		delete(database, path)
+	}
+}
 
-		if digest != databaseDigest {
-			databaseDigest = digest
-			// Database has been modified, so upload it
-			fmt.Println("Uploading database...")
-			err = b.Store("db.gosafe", data)
-			if err != nil {
-				fmt.Printf("Failed to upload database: %v\n", err)
-			}
+// updateMetadata keeps the metadata and entropy of the files of the plan up
+// to date, even when their content didn't change.
+func updateMetadata(plan *Plan) {
+	for _, update := range plan.Updates {
+		file := database[update.SavePath]
+		file.setMetadata(update.Info)
+		file.Entropy = math.Round(update.Entropy*100) / 100
+		if file.Deleted != 0 {
+			fmt.Println("Keeping", update.SavePath, "(reappeared)...")
+			file.Deleted = 0
 		}
-
-		time.Sleep(duration)
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yyewolf/go-safe/merkle"
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// uploadManifest uploads the Merkle tree of the current snapshot, which ties
+// every file of the index together under a single root hash. It is bound to
+// the version of the generation of the index it belongs to.
+func uploadManifest(b storage.StorageBackend, key string, version uint64) error {
+	files := make(map[string]string)
+	for path, file := range database {
+		files[path] = file.Sum
+	}
+
+	tree, err := merkle.Build(files)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Store(key, version, data); err != nil {
+		return err
+	}
+
+	fmt.Println("Snapshot root hash:", tree.Hash)
+	return nil
+}
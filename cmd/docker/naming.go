@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yyewolf/go-safe/naming"
+)
+
+func namer() naming.Namer {
+	switch config.Naming.Scheme {
+	case "plain", "":
+		return naming.NewPlainNamer()
+	case "hmac":
+		return hmacNamer()
+	case "random":
+		return naming.NewRandomNamer()
+	}
+
+	fmt.Printf("Unknown naming scheme: %s\n", config.Naming.Scheme)
+	os.Exit(1)
+	return nil
+}
+
+// objectName returns the key of a new object for the file. In append-only
+// mode every version gets its own key, so that nothing is overwritten. The
+// version and upload time are named along with the path, so that opaque names
+// reveal neither.
+func objectName(n naming.Namer, path string, version uint64) (string, error) {
+	if config.AppendOnly {
+		path = fmt.Sprintf("%s@v%d-%d", path, version, time.Now().Unix())
+	}
+
+	return n.Name(path)
+}
+
+func hmacNamer() naming.Namer {
+	if config.Naming.KeyLocation == "" {
+		fmt.Println("HMAC naming requires a key location")
+		os.Exit(1)
+	}
+
+	// Check key file permissions and existence
+	st, err := os.Stat(config.Naming.KeyLocation)
+	if err != nil {
+		fmt.Printf("Failed to stat naming key file: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Key should only be readable by the owner
+	if st.Mode() != 0600 && st.Mode() != 0400 {
+		fmt.Println("Naming key file permissions are too open")
+		os.Exit(1)
+	}
+
+	// Read the key file
+	key, err := os.ReadFile(config.Naming.KeyLocation)
+	if err != nil {
+		fmt.Printf("Failed to read naming key file: %v\n", err)
+		os.Exit(1)
+	}
+
+	namer, err := naming.NewHMACNamer(key)
+	if err != nil {
+		fmt.Printf("Failed to configure naming: %v\n", err)
+		os.Exit(1)
+	}
+
+	return namer
+}
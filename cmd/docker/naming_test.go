@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yyewolf/go-safe/naming"
+)
+
+func TestObjectName(t *testing.T) {
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+	})
+	config = Config{}
+	config.AppendOnly = true
+
+	hmacNamer, err := naming.NewHMACNamer(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		namer naming.Namer
+		// Whether the version and upload time can be read from the key
+		visible bool
+	}{
+		{"plain", naming.NewPlainNamer(), true},
+		{"hmac", hmacNamer, false},
+		{"random", naming.NewRandomNamer(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := objectName(tt.namer, "etc/passwd", 1)
+			if err != nil {
+				t.Fatalf("Failed to name object: %v", err)
+			}
+			second, err := objectName(tt.namer, "etc/passwd", 2)
+			if err != nil {
+				t.Fatalf("Failed to name object: %v", err)
+			}
+
+			if first == second {
+				t.Errorf("Expected every version to get its own key, got %s twice", first)
+			}
+			if visible := strings.Contains(second, "@v2-"); visible != tt.visible {
+				t.Errorf("Expected the version to be visible in %s: %v", second, tt.visible)
+			}
+		})
+	}
+}
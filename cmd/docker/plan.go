@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yyewolf/go-safe/anomaly"
+	"github.com/yyewolf/go-safe/repository"
+)
+
+// Upload is a file to upload because it is new or was modified.
+type Upload struct {
+	// Path is the path of the file on disk, SavePath its path in the index
+	Path     string
+	SavePath string
+	Sum      string
+	Info     os.FileInfo
+	New      bool
+	// Legacy is whether the unchanged file is uploaded again, its object
+	// having been stored before envelopes, bound to nothing
+	Legacy bool
+	// Entropy of the content, in bits per byte
+	Entropy float64
+}
+
+// Plan lists what a backup pass is going to do.
+type Plan struct {
+	Uploads []*Upload
+	// Updates are files with the same content but different metadata, or no
+	// recorded entropy
+	Updates []*Upload
+	// Tombstones are the index paths of the files which just disappeared
+	Tombstones []string
+	// Deletes are the index paths of the files whose grace period is over
+	Deletes []string
+	// Aborted is why the deletion pass was aborted, if it was
+	Aborted string
+	// Anomalies are why the changes look like ransomware, if they do
+	Anomalies []string
+}
+
+// scan walks the backup directory and compares it against the database,
+// without uploading nor changing anything. Deletions are only planned when
+// the whole directory could be walked: missing files are first tombstoned,
+// and only deleted once they have been missing for the grace period.
+func scan() (*Plan, error) {
+	plan := &Plan{}
+	seen := make(map[string]bool)
+	cycle := &anomaly.Cycle{}
+
+	err := filepath.Walk(config.Backup.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip if it's not a readable file
+		if !info.Mode().IsRegular() || info.Mode()&0400 == 0 {
+			return nil
+		}
+
+		savePath := path
+		// Remove the backup directory from the path and add s3Dir if it's set
+		if strings.HasPrefix(path, config.Backup.Dir) {
+			savePath = path[len(config.Backup.Dir):]
+			if savePath[0] == filepath.Separator {
+				savePath = savePath[1:]
+			}
+		}
+
+		if savePath == "db.gosafe" || savePath == "manifest.gosafe" || savePath == "alert.gosafe" || savePath == "host.gosafe" || savePath == "repository.gosafe" {
+			return nil
+		}
+
+		// Its key would collide with the objects of the repository
+		if repository.Reserved(filepath.ToSlash(savePath)) {
+			fmt.Printf("Skipping %s, %s is reserved for the repository\n", path, repository.Prefix)
+			return nil
+		}
+		// Left behind by a restore into the backup directory
+		if repository.Temporary(filepath.ToSlash(savePath)) {
+			return nil
+		}
+		seen[savePath] = true
+
+		// Read the file
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("Failed to read %s: %v\n", path, err)
+			return nil
+		}
+
+		// SHA256 sum the file
+		sum := sha256.Sum256(data)
+		upload := &Upload{
+			Path:     path,
+			SavePath: savePath,
+			Sum:      hex.EncodeToString(sum[:]),
+			Info:     info,
+			Entropy:  anomaly.Entropy(data),
+		}
+
+		// Check if the file is already in the database, and if it has been modified
+		file, ok := database[savePath]
+		switch {
+		case !ok:
+			upload.New = true
+			plan.Uploads = append(plan.Uploads, upload)
+		case file.Sum != upload.Sum:
+			plan.Uploads = append(plan.Uploads, upload)
+			cycle.Changed++
+			if anomaly.EntropyJump(file.Entropy, upload.Entropy) {
+				cycle.EntropyJumps++
+			}
+		case file.Version == 0:
+			// Objects are only version 0 if stored before envelopes, which
+			// the retriever refuses unless --allow-legacy is given
+			upload.Legacy = true
+			plan.Uploads = append(plan.Uploads, upload)
+		case file.Size != info.Size() || file.ModTime != info.ModTime().Unix() || file.Mode != info.Mode().Perm() || file.Deleted != 0:
+			plan.Updates = append(plan.Updates, upload)
+		case file.Entropy == 0:
+			// Indexed before entropy was recorded, without it encrypting the
+			// file in place would go unnoticed
+			plan.Updates = append(plan.Updates, upload)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return plan, err
+	}
+
+	// Ransomware usually renames the files it encrypts, e.g. report.pdf to
+	// report.pdf.locked or report.locked, so look for the missing originals
+	missing := make(map[string]bool)
+	for path, file := range database {
+		if file.Deleted == 0 {
+			cycle.Files++
+			if !seen[path] {
+				missing[path] = true
+				missing[strings.TrimSuffix(path, filepath.Ext(path))] = true
+			}
+		}
+	}
+	for _, upload := range plan.Uploads {
+		ext := filepath.Ext(upload.SavePath)
+		if upload.New && ext != "" && missing[strings.TrimSuffix(upload.SavePath, ext)] {
+			cycle.Renamed++
+		}
+	}
+	plan.Anomalies = detector().Check(cycle)
+
+	if config.Sync {
+		now := time.Now()
+		live := 0
+		for path, file := range database {
+			if file.Deleted == 0 {
+				live++
+			}
+			if seen[path] {
+				continue
+			}
+
+			switch {
+			case file.Deleted == 0:
+				plan.Tombstones = append(plan.Tombstones, path)
+			case now.Sub(time.Unix(file.Deleted, 0)) >= config.GracePeriod:
+				plan.Deletes = append(plan.Deletes, path)
+			}
+		}
+		sort.Strings(plan.Tombstones)
+		sort.Strings(plan.Deletes)
+
+		// An unmounted volume or a mass deletion makes many files disappear at once
+		if live > 0 && float64(len(plan.Tombstones))*100 > config.DeleteThreshold*float64(live) {
+			plan.Aborted = fmt.Sprintf("%d of %d files disappeared, more than the %g%% threshold", len(plan.Tombstones), live, config.DeleteThreshold)
+			plan.Tombstones = nil
+			plan.Deletes = nil
+		}
+	}
+
+	return plan, nil
+}
+
+// print prints the plan with its byte totals.
+func (p *Plan) print() {
+	var uploadBytes, deleteBytes int64
+
+	for _, upload := range p.Uploads {
+		status := "modified"
+		if upload.New {
+			status = "new"
+		}
+		fmt.Printf("upload  %-8s  %10d  %s\n", status, upload.Info.Size(), upload.SavePath)
+		uploadBytes += upload.Info.Size()
+	}
+
+	for _, path := range p.Tombstones {
+		fmt.Printf("delete  %-8s  %10d  %s\n", "pending", database[path].Size, path)
+	}
+
+	for _, path := range p.Deletes {
+		fmt.Printf("delete  %-8s  %10d  %s\n", "expired", database[path].Size, path)
+		deleteBytes += database[path].Size
+	}
+
+	if p.Aborted != "" {
+		fmt.Printf("Deletion pass aborted: %s\n", p.Aborted)
+	}
+
+	for _, reason := range p.Anomalies {
+		fmt.Printf("Anomaly, uploads would be paused: %s\n", reason)
+	}
+
+	fmt.Printf("%d files to upload (%d bytes), %d files to delete (%d bytes), %d files to tombstone, %d metadata updates\n",
+		len(p.Uploads), uploadBytes, len(p.Deletes), deleteBytes, len(p.Tombstones), len(p.Updates))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanDeletions(t *testing.T) {
+	now := time.Now()
+	present := []string{"a.txt", "b.txt", "c.txt"}
+
+	tests := []struct {
+		name string
+		sync bool
+		// Files of the index missing from the backup directory, with when
+		// they were first found missing (0 if they weren't yet)
+		missing    map[string]time.Time
+		tombstones []string
+		deletes    []string
+		aborted    bool
+	}{
+		{
+			name:    "without sync",
+			missing: map[string]time.Time{"gone.txt": {}},
+		},
+		{
+			name:       "just disappeared",
+			sync:       true,
+			missing:    map[string]time.Time{"gone.txt": {}},
+			tombstones: []string{"gone.txt"},
+		},
+		{
+			name:    "within the grace period",
+			sync:    true,
+			missing: map[string]time.Time{"gone.txt": now.Add(-time.Hour)},
+		},
+		{
+			name:    "grace period over",
+			sync:    true,
+			missing: map[string]time.Time{"gone.txt": now.Add(-48 * time.Hour)},
+			deletes: []string{"gone.txt"},
+		},
+		{
+			// 4 of the 7 indexed files, over the 50% threshold
+			name: "mass disappearance",
+			sync: true,
+			missing: map[string]time.Time{
+				"x.txt": {},
+				"y.txt": {},
+				"z.txt": {},
+				"w.txt": {},
+				"old":   now.Add(-48 * time.Hour),
+			},
+			aborted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setupScan(t, dir, tt.sync)
+
+			for _, path := range present {
+				writeFile(t, dir, path)
+				database[path] = &File{Version: 1}
+			}
+			for path, deleted := range tt.missing {
+				database[path] = &File{Version: 1}
+				if !deleted.IsZero() {
+					database[path].Deleted = deleted.Unix()
+				}
+			}
+
+			plan, err := scan()
+			if err != nil {
+				t.Fatalf("Failed to scan: %v", err)
+			}
+
+			if !reflect.DeepEqual(plan.Tombstones, tt.tombstones) {
+				t.Errorf("Expected tombstones %v, got %v", tt.tombstones, plan.Tombstones)
+			}
+			if !reflect.DeepEqual(plan.Deletes, tt.deletes) {
+				t.Errorf("Expected deletes %v, got %v", tt.deletes, plan.Deletes)
+			}
+			if (plan.Aborted != "") != tt.aborted {
+				t.Errorf("Expected aborted to be %v, got %q", tt.aborted, plan.Aborted)
+			}
+		})
+	}
+}
+
+func TestScanReserved(t *testing.T) {
+	dir := t.TempDir()
+	setupScan(t, dir, true)
+
+	// Files of the daemon and those colliding with the repository are skipped
+	// e.g. left behind by a restore into the backup directory
+	for _, path := range []string{"a.txt", "index/a.txt", "db.gosafe", "alert.gosafe", ".gosafe/index/x.gosafe",
+		".gosafe/quarantine/a.txt", "index/.gosafe-123456"} {
+		writeFile(t, dir, path)
+	}
+
+	plan, err := scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+
+	var uploads []string
+	for _, upload := range plan.Uploads {
+		uploads = append(uploads, filepath.ToSlash(upload.SavePath))
+	}
+	if expected := []string{"a.txt", "index/a.txt"}; !reflect.DeepEqual(uploads, expected) {
+		t.Errorf("Expected uploads %v, got %v", expected, uploads)
+	}
+}
+
+func TestScanEntropy(t *testing.T) {
+	dir := t.TempDir()
+	setupScan(t, dir, false)
+	config.Anomaly.Entropy = 20
+	config.Anomaly.MinFiles = 1
+
+	// Text files indexed before entropy was recorded
+	text := []byte(strings.Repeat("All work and no play makes Jack a dull boy. ", 100))
+	paths := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	for _, path := range paths {
+		if err := os.WriteFile(filepath.Join(dir, path), text, 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(text)
+		file := &File{Sum: hex.EncodeToString(sum[:]), Version: 1}
+		file.setMetadata(info)
+		database[path] = file
+	}
+
+	// Unchanged files get their entropy recorded
+	plan, err := scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if len(plan.Updates) != len(paths) || len(plan.Uploads) != 0 {
+		t.Fatalf("Expected %d updates and no upload, got %d and %d", len(paths), len(plan.Updates), len(plan.Uploads))
+	}
+	updateMetadata(plan)
+	for _, path := range paths {
+		if database[path].Entropy == 0 {
+			t.Errorf("Expected the entropy of %s to be recorded", path)
+		}
+	}
+	if plan, err := scan(); err != nil || len(plan.Updates) != 0 {
+		t.Fatalf("Expected no more updates, got %v", err)
+	}
+
+	// Encrypting the files in place is noticed
+	for _, path := range paths {
+		random := make([]byte, len(text))
+		rand.Read(random)
+		if err := os.WriteFile(filepath.Join(dir, path), random, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err = scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if len(plan.Anomalies) == 0 {
+		t.Error("Expected the encrypted files to be reported as an anomaly")
+	}
+}
+
+func TestScanLegacy(t *testing.T) {
+	dir := t.TempDir()
+	setupScan(t, dir, false)
+
+	// Unchanged files whose object was stored before envelopes, or not
+	for path, version := range map[string]uint64{"legacy.txt": 0, "bound.txt": 1} {
+		writeFile(t, dir, path)
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		file := &File{Sum: hex.EncodeToString(sum[:]), Version: version, Entropy: 4}
+		file.setMetadata(info)
+		database[path] = file
+	}
+
+	plan, err := scan()
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if len(plan.Uploads) != 1 || plan.Uploads[0].SavePath != "legacy.txt" || !plan.Uploads[0].Legacy || plan.Uploads[0].New {
+		t.Fatalf("Expected legacy.txt to be uploaded again, got %+v", plan.Uploads)
+	}
+	if len(plan.Updates) != 0 {
+		t.Errorf("Expected no update, got %d", len(plan.Updates))
+	}
+}
+
+func TestScanError(t *testing.T) {
+	setupScan(t, filepath.Join(t.TempDir(), "missing"), true)
+	database["a.txt"] = &File{}
+
+	if _, err := scan(); err == nil {
+		t.Fatal("Expected an error scanning a missing backup directory")
+	}
+}
+
+// setupScan points the configuration to the backup directory with an empty
+// database, restoring them once the test is over.
+func setupScan(t *testing.T, dir string, sync bool) {
+	savedConfig, savedDatabase := config, database
+	t.Cleanup(func() {
+		config, database = savedConfig, savedDatabase
+	})
+
+	config = Config{}
+	config.Backup.Dir = dir
+	config.Sync = sync
+	config.GracePeriod = 24 * time.Hour
+	config.DeleteThreshold = 50
+	database = make(map[string]*File)
+}
+
+func writeFile(t *testing.T, dir string, path string) {
+	path = filepath.Join(dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(path), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yyewolf/go-safe/lock"
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// host is the identity of this host, namespace the prefix of its objects in
+// the repository (empty for repositories from before namespaces), and
+// repositoryID the ID bound to its objects.
+var host, namespace, repositoryID string
+
+// openRepository identifies this host and loads the repository config,
+// creating it for a new repository, exiting on failure.
+func openRepository() {
+	if config.S3.AccessID == "" {
+		fmt.Println("No storage backend configured")
+		os.Exit(1)
+	}
+
+	host = hostID()
+
+	// The config is stored directly in S3, outside of the namespaces
+	root := s3BackendAt(config.S3.Dir)
+	repo, err := repository.Load(root)
+	if errors.Is(err, storage.ErrNotFound) {
+		repo, err = createRepository(root)
+	}
+	if err != nil {
+		fmt.Printf("Failed to open repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Objects encrypted differently couldn't be restored along the others
+	err = repo.Check(config.Repository.ID, encryptionConfig().Backend)
+	if err != nil {
+		fmt.Printf("Refusing to back up into this repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Objects bound to another repository ID could never be restored
+	err = recordRepository(repo.ID)
+	if err != nil {
+		fmt.Printf("Refusing to back up into this repository: %v\n", err)
+		os.Exit(1)
+	}
+
+	namespace = repo.Namespace(host)
+	repositoryID = repo.BoundID(host)
+}
+
+// repositoryFile returns where the ID of the repository this host backs up
+// into is recorded.
+func repositoryFile() string {
+	return filepath.Join(config.Backup.Dir, "repository.gosafe")
+}
+
+// recordRepository records the ID of the repository on the first run, and
+// returns an error if this host backed up into another repository before.
+func recordRepository(id string) error {
+	data, err := os.ReadFile(repositoryFile())
+	switch {
+	case err == nil:
+		if recorded := strings.TrimSpace(string(data)); recorded != id {
+			return fmt.Errorf("%w: repository ID is %q, but this host backed up into %q", repository.ErrMismatch, id, recorded)
+		}
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		if config.DryRun {
+			return nil
+		}
+		return os.WriteFile(repositoryFile(), []byte(id+"\n"), 0600)
+	default:
+		return err
+	}
+}
+
+// checkRepository returns an error if the config of the repository no longer
+// has the ID this host backs up into, e.g. if it was overwritten.
+func checkRepository() error {
+	repo, err := repository.Load(s3BackendAt(config.S3.Dir))
+	if err != nil {
+		return err
+	}
+
+	return recordRepository(repo.ID)
+}
+
+// createRepository creates the config of the repository. Repositories holding
+// an index from before the config keep storing a single host at their root,
+// under the configured repository ID.
+//
+// Storage backends can't store an object only if it doesn't exist, so the
+// config is only created under an exclusive lock at the root of the
+// repository: a host starting at the same time waits for it, then uses the
+// stored config instead of overwriting it with another repository ID.
+func createRepository(root storage.StorageBackend) (*repository.Config, error) {
+	if config.DryRun {
+		return newRepository(root)
+	}
+
+	locker, err := lock.NewLocker(root, config.Lock.TTL)
+	if err != nil {
+		return nil, err
+	}
+	held, err := lockRoot(locker)
+	if err != nil {
+		return nil, err
+	}
+	defer locker.Unlock(held)
+
+	repo, err := repository.Load(root)
+	if err == nil {
+		// Created by another host in the meantime
+		return repo, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	repo, err = newRepository(root)
+	if err != nil {
+		return nil, err
+	}
+	err = repository.Save(root, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Created repository %s\n", repo.ID)
+	return repo, nil
+}
+
+// newRepository returns the config of a new repository, without storing it.
+func newRepository(root storage.StorageBackend) (*repository.Config, error) {
+	legacy, err := repository.Legacy(root)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := repository.NewConfig(config.Repository.ID, encryptionConfig())
+	if err != nil {
+		return nil, err
+	}
+	if legacy {
+		repo.ID = config.Repository.ID
+		repo.Namespaces = false
+	}
+
+	return repo, nil
+}
+
+// lockRoot takes an exclusive lock at the root of the repository. Hosts
+// racing for it both back off, so they retry after a random delay.
+func lockRoot(locker *lock.Locker) (*lock.Lock, error) {
+	for attempt := 1; ; attempt++ {
+		held, err := locker.Lock(true)
+		if !errors.Is(err, lock.ErrLocked) || attempt == 10 {
+			return held, err
+		}
+		time.Sleep(time.Duration(1000+rand.Intn(4000)) * time.Millisecond)
+	}
+}
+
+// hostID returns the identity of this host: --host.id, else the one recorded
+// in the backup directory, else the hostname, which is then recorded so that
+// the identity survives e.g. recreating the container.
+func hostID() string {
+	id := config.Host.ID
+	if id == "" {
+		file := filepath.Join(config.Backup.Dir, "host.gosafe")
+		data, err := os.ReadFile(file)
+		switch {
+		case err == nil:
+			id = strings.TrimSpace(string(data))
+		case errors.Is(err, os.ErrNotExist):
+			id, err = os.Hostname()
+			if err != nil {
+				fmt.Printf("Failed to get hostname, use --host.id: %v\n", err)
+				os.Exit(1)
+			}
+			if !config.DryRun {
+				err = os.WriteFile(file, []byte(id+"\n"), 0600)
+				if err != nil {
+					fmt.Printf("Failed to record host identity: %v\n", err)
+					os.Exit(1)
+				}
+			}
+		default:
+			fmt.Printf("Failed to read host identity: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := repository.ValidateHost(id); err != nil {
+		fmt.Printf("Invalid host identity: %v\n", err)
+		os.Exit(1)
+	}
+
+	return id
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+func TestCreateRepositoryConcurrently(t *testing.T) {
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+	})
+	config = Config{}
+	config.AES.KeyLocation = "aes.key"
+	config.Lock.TTL = time.Minute
+
+	// Hosts starting at the same time all end up in the same repository
+	root := storagetest.NewMemoryBackend()
+	ids := make([]string, 2)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repo, err := createRepository(root)
+			if err != nil {
+				t.Errorf("Failed to create repository: %v", err)
+				return
+			}
+			ids[i] = repo.ID
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := repository.Load(root)
+	if err != nil {
+		t.Fatalf("Failed to load repository: %v", err)
+	}
+	for _, id := range ids {
+		if id != stored.ID {
+			t.Errorf("Expected every host to use repository %s, got %s", stored.ID, id)
+		}
+	}
+}
+
+func TestRecordRepository(t *testing.T) {
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+	})
+	config = Config{}
+	config.Backup.Dir = t.TempDir()
+
+	if err := recordRepository("first"); err != nil {
+		t.Fatalf("Failed to record repository: %v", err)
+	}
+	if err := recordRepository("first"); err != nil {
+		t.Errorf("Expected the recorded repository to be accepted, got %v", err)
+	}
+
+	// e.g. the config was overwritten by another host
+	if err := recordRepository("second"); !errors.Is(err, repository.ErrMismatch) {
+		t.Errorf("Expected ErrMismatch for another repository, got %v", err)
+	}
+}
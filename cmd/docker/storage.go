@@ -3,25 +3,50 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/yyewolf/go-safe/compression"
 	"github.com/yyewolf/go-safe/encryption"
 	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/transform"
 )
 
-func storageBackend(encryptionBackend encryption.EncryptionBackend) storage.StorageBackend {
+func storageBackend(encryptionBackend encryption.EncryptionBackend) *transform.Pipeline {
 	if config.S3.AccessID != "" {
-		return s3Backend(encryptionBackend)
+		b := s3Backend()
+		if config.AppendOnly {
+			b = appendOnly(b)
+		}
+		return pipeline(b, encryptionBackend)
 	}
 	return nil
 }
 
-func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBackend {
+// appendOnly wraps the storage backend so that nothing can be deleted nor
+// overwritten, even by mistake.
+func appendOnly(b storage.StorageBackend) storage.StorageBackend {
+	appendOnlyBackend, err := storage.NewAppendOnlyBackend(b)
+	if err != nil {
+		fmt.Printf("Failed to configure append-only backend: %v\n", err)
+		os.Exit(1)
+	}
+
+	return appendOnlyBackend
+}
+
+// s3Backend returns the S3 backend of the namespace of this host.
+func s3Backend() storage.StorageBackend {
+	return s3BackendAt(filepath.Join(config.S3.Dir, namespace))
+}
+
+// s3BackendAt returns an S3 backend storing under the directory.
+func s3BackendAt(dir string) storage.StorageBackend {
 	// Configure S3 backend
 	s3Config := &storage.S3Config{
 		StorageClass: config.S3.StorageClass,
-		Prepend:      config.S3.Dir,
+		Prepend:      dir,
 		Bucket:       config.S3.BucketName,
 		Config: aws.NewConfig().
 			WithCredentials(
@@ -41,7 +66,7 @@ func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBa
 		s3Config.Config = s3Config.Config.WithEndpoint(config.S3.Endpoint)
 	}
 
-	s3Backend, err := storage.NewS3Backend(s3Config, encryptionBackend)
+	s3Backend, err := storage.NewS3Backend(s3Config)
 	if err != nil {
 		fmt.Printf("Failed to configure S3 backend: %v\n", err)
 		os.Exit(1)
@@ -49,3 +74,75 @@ func s3Backend(encryptionBackend encryption.EncryptionBackend) storage.StorageBa
 
 	return s3Backend
 }
+
+// pipeline wraps the storage backend with the stages applied to every object.
+func pipeline(b storage.StorageBackend, encryptionBackend encryption.EncryptionBackend) *transform.Pipeline {
+	compressor, err := compression.NewCompressor(compression.Algorithm(config.Compression.Algorithm), config.Compression.Level)
+	if err != nil {
+		fmt.Printf("Failed to configure compression: %v\n", err)
+		os.Exit(1)
+	}
+
+	transformers := []transform.Transformer{transform.NewCompressTransformer(compressor)}
+
+	// Padding is applied after compression, which would undo it
+	if config.Padding != "none" && config.Padding != "" {
+		padTransformer, err := transform.NewPadTransformer(transform.PaddingScheme(config.Padding))
+		if err != nil {
+			fmt.Printf("Failed to configure padding: %v\n", err)
+			os.Exit(1)
+		}
+		transformers = append(transformers, padTransformer)
+	}
+
+	transformers = append(transformers, transform.NewEncryptTransformer(encryptionBackend))
+
+	// Signing comes last, so that objects are verified before anything else
+	if config.Sign.PrivateKeyLocation != "" {
+		transformers = append(transformers, signTransformer())
+	}
+
+	p, err := transform.NewPipeline(b, repositoryID, transformers...)
+	if err != nil {
+		fmt.Printf("Failed to configure transform pipeline: %v\n", err)
+		os.Exit(1)
+	}
+
+	return p
+}
+
+func signTransformer() transform.Transformer {
+	// Check key file permissions and existence
+	st, err := os.Stat(config.Sign.PrivateKeyLocation)
+	if err != nil {
+		fmt.Printf("Failed to stat signing key file: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Key should only be readable by the owner
+	if st.Mode() != 0600 && st.Mode() != 0400 {
+		fmt.Println("Signing key file permissions are too open")
+		os.Exit(1)
+	}
+
+	// Read the key file
+	data, err := os.ReadFile(config.Sign.PrivateKeyLocation)
+	if err != nil {
+		fmt.Printf("Failed to read signing key file: %v\n", err)
+		os.Exit(1)
+	}
+
+	privateKey, err := transform.ParseSigningPrivateKey(data)
+	if err != nil {
+		fmt.Printf("Failed to parse signing key: %v\n", err)
+		os.Exit(1)
+	}
+
+	signTransformer, err := transform.NewSignTransformer(privateKey)
+	if err != nil {
+		fmt.Printf("Failed to configure signing: %v\n", err)
+		os.Exit(1)
+	}
+
+	return signTransformer
+}
@@ -0,0 +1,197 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm represents a compression algorithm.
+type Algorithm string
+
+const (
+	AlgorithmNone Algorithm = "none"
+	AlgorithmGzip Algorithm = "gzip"
+	AlgorithmZstd Algorithm = "zstd"
+)
+
+// algorithmIDs are the identifiers of the algorithms in the header.
+var algorithmIDs = map[Algorithm]byte{
+	AlgorithmNone: 0,
+	AlgorithmGzip: 1,
+	AlgorithmZstd: 2,
+}
+
+// headerMagic prefixes compressed data. Like the PNG signature, it contains
+// bytes that are unlikely to start any text or binary file.
+var headerMagic = []byte("\x89GSZ\r\n\x1a\n")
+
+// headerSize is the size of the magic followed by the algorithm identifier.
+var headerSize = len(headerMagic) + 1
+
+// Compressor compresses data with an algorithm and level, recording the
+// algorithm in a header so that it can be decompressed without configuration.
+type Compressor struct {
+	algorithm Algorithm
+	level     int
+}
+
+// NewCompressor creates a new compressor. A level of 0 selects the default
+// level of the algorithm: 1 to 9 for gzip, 1 to 22 for zstd.
+func NewCompressor(algorithm Algorithm, level int) (*Compressor, error) {
+	switch algorithm {
+	case AlgorithmNone:
+	case AlgorithmGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d", level)
+		}
+	case AlgorithmZstd:
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d", level)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algorithm)
+	}
+
+	return &Compressor{
+		algorithm: algorithm,
+		level:     level,
+	}, nil
+}
+
+// Compress compresses the data. Incompressible data is stored raw behind the
+// header, either because it is already compressed or because it doesn't shrink.
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	if c.algorithm == AlgorithmNone || Incompressible(data) {
+		return frame(AlgorithmNone, data), nil
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(header(c.algorithm))
+
+	switch c.algorithm {
+	case AlgorithmGzip:
+		level := c.level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err := gzip.NewWriterLevel(out, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case AlgorithmZstd:
+		level := zstd.SpeedDefault
+		if c.level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.level)
+		}
+		w, err := zstd.NewWriter(out, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Don't pay for decompression if nothing was gained
+	if out.Len() >= len(data)+headerSize {
+		return frame(AlgorithmNone, data), nil
+	}
+
+	return out.Bytes(), nil
+}
+
+// Decompress decompresses data produced by Compress. Data without a header was
+// stored before compression existed, and is returned as is.
+func Decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, headerMagic) {
+		return data, nil
+	}
+	if len(data) < headerSize {
+		return nil, errors.New("compression header is truncated")
+	}
+
+	payload := data[headerSize:]
+	switch data[len(headerMagic)] {
+	case algorithmIDs[AlgorithmNone]:
+		return payload, nil
+	case algorithmIDs[AlgorithmGzip]:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case algorithmIDs[AlgorithmZstd]:
+		r, err := zstd.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", data[len(headerMagic)])
+	}
+}
+
+// incompressibleSignatures are the leading bytes of already compressed formats.
+var incompressibleSignatures = [][]byte{
+	[]byte("\xff\xd8\xff"),            // JPEG
+	[]byte("\x89PNG\r\n\x1a\n"),       // PNG
+	[]byte("GIF8"),                    // GIF
+	[]byte("PK\x03\x04"),              // zip, docx, xlsx, jar, apk...
+	[]byte("\x1f\x8b"),                // gzip
+	[]byte("\x28\xb5\x2f\xfd"),        // zstd
+	[]byte("BZh"),                     // bzip2
+	[]byte("\xfd7zXZ\x00"),            // xz
+	[]byte("7z\xbc\xaf\x27\x1c"),      // 7z
+	[]byte("Rar!\x1a\x07"),            // rar
+	[]byte("\x04\x22\x4d\x18"),        // lz4
+	[]byte("ID3"),                     // mp3
+	[]byte("OggS"),                    // ogg
+	[]byte("fLaC"),                    // flac
+	[]byte("\x1a\x45\xdf\xa3"),        // mkv, webm
+	[]byte("age-encryption.org/v1\n"), // age
+}
+
+// Incompressible reports whether the data starts like an already compressed format.
+func Incompressible(data []byte) bool {
+	for _, signature := range incompressibleSignatures {
+		if bytes.HasPrefix(data, signature) {
+			return true
+		}
+	}
+
+	// RIFF containers (webp, avi) and ISO media (mp4, mov, heic)
+	if len(data) >= 12 {
+		if bytes.HasPrefix(data, []byte("RIFF")) && (bytes.Equal(data[8:12], []byte("WEBP")) || bytes.Equal(data[8:12], []byte("AVI "))) {
+			return true
+		}
+		if bytes.Equal(data[4:8], []byte("ftyp")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func header(algorithm Algorithm) []byte {
+	return append(append([]byte{}, headerMagic...), algorithmIDs[algorithm])
+}
+
+func frame(algorithm Algorithm, data []byte) []byte {
+	return append(header(algorithm), data...)
+}
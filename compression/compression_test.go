@@ -0,0 +1,123 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompressor(t *testing.T) {
+	logs := []byte(strings.Repeat("2023-06-13 13:37:00 INFO request served in 12ms\n", 10000))
+
+	random := make([]byte, 1024*1024) // 1 MB
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("Failed to generate random data: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		level     int
+	}{
+		{"None", AlgorithmNone, 0},
+		{"Gzip", AlgorithmGzip, 0},
+		{"GzipBest", AlgorithmGzip, 9},
+		{"Zstd", AlgorithmZstd, 0},
+		{"ZstdBest", AlgorithmZstd, 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor, err := NewCompressor(tt.algorithm, tt.level)
+			if err != nil {
+				t.Fatalf("Failed to initialize compressor: %v", err)
+			}
+
+			// Test compressible data
+			compressed, err := compressor.Compress(logs)
+			if err != nil {
+				t.Fatalf("Failed to compress logs: %v", err)
+			}
+			if tt.algorithm != AlgorithmNone && len(compressed) > len(logs)/5 {
+				t.Fatalf("Logs were not compressed: %d bytes out of %d", len(compressed), len(logs))
+			}
+			decompressed, err := Decompress(compressed)
+			if err != nil {
+				t.Fatalf("Failed to decompress logs: %v", err)
+			}
+			if !bytes.Equal(logs, decompressed) {
+				t.Fatal("Logs compression and decompression failed: data mismatch")
+			}
+
+			// Incompressible data must be stored raw
+			compressed, err = compressor.Compress(random)
+			if err != nil {
+				t.Fatalf("Failed to compress random data: %v", err)
+			}
+			if len(compressed) != len(random)+headerSize {
+				t.Fatalf("Random data was not stored raw: %d bytes out of %d", len(compressed), len(random))
+			}
+			decompressed, err = Decompress(compressed)
+			if err != nil {
+				t.Fatalf("Failed to decompress random data: %v", err)
+			}
+			if !bytes.Equal(random, decompressed) {
+				t.Fatal("Random data compression and decompression failed: data mismatch")
+			}
+		})
+	}
+
+	// Invalid levels must be rejected
+	if _, err := NewCompressor(AlgorithmGzip, 10); err == nil {
+		t.Fatal("Invalid gzip level was accepted")
+	}
+	if _, err := NewCompressor("lz4", 0); err == nil {
+		t.Fatal("Unknown algorithm was accepted")
+	}
+}
+
+func TestCompressorIncompressible(t *testing.T) {
+	compressor, err := NewCompressor(AlgorithmZstd, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize compressor: %v", err)
+	}
+
+	// A compressible payload behind a known signature is still stored raw
+	padding := bytes.Repeat([]byte{0}, 4096)
+	for _, signature := range [][]byte{[]byte("\xff\xd8\xff\xe0"), []byte("PK\x03\x04")} {
+		data := append(append([]byte{}, signature...), padding...)
+		compressed, err := compressor.Compress(data)
+		if err != nil {
+			t.Fatalf("Failed to compress data: %v", err)
+		}
+		if compressed[len(headerMagic)] != algorithmIDs[AlgorithmNone] {
+			t.Fatalf("Data starting with %q was compressed", signature)
+		}
+	}
+}
+
+func TestDecompressLegacy(t *testing.T) {
+	// Data stored before compression has no header
+	data := []byte("This is a small file.")
+	decompressed, err := Decompress(data)
+	if err != nil {
+		t.Fatalf("Failed to decompress legacy data: %v", err)
+	}
+	if !bytes.Equal(data, decompressed) {
+		t.Fatal("Legacy data decompression failed: data mismatch")
+	}
+
+	// Corrupt compressed data must be rejected
+	compressor, err := NewCompressor(AlgorithmGzip, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize compressor: %v", err)
+	}
+	compressed, err := compressor.Compress(bytes.Repeat(data, 100))
+	if err != nil {
+		t.Fatalf("Failed to compress data: %v", err)
+	}
+	if _, err := Decompress(compressed[:len(compressed)-4]); err == nil {
+		t.Fatal("Truncated data was decompressed")
+	}
+}
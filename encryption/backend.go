@@ -9,8 +9,10 @@ type EncryptionBackend interface {
 	Initialize(config EncryptionConfig) error
 
 	// Encrypt encrypts the provided data using the public key (for assymetrical encryption).
-	Encrypt(data []byte) ([]byte, error)
+	// The associated data is authenticated but not encrypted, decryption fails
+	// unless the exact same associated data is provided.
+	Encrypt(data []byte, associatedData []byte) ([]byte, error)
 
 	// Decrypt decrypts the provided encrypted data using the private key (for assymetrical encryption).
-	Decrypt(encryptedData []byte) ([]byte, error)
+	Decrypt(encryptedData []byte, associatedData []byte) ([]byte, error)
 }
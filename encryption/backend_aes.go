@@ -48,7 +48,7 @@ func (e *AESEncryptionBackend) Initialize(cfg EncryptionConfig) error {
 }
 
 // Encrypt encrypts the provided data using AES encryption.
-func (e *AESEncryptionBackend) Encrypt(data []byte) ([]byte, error) {
+func (e *AESEncryptionBackend) Encrypt(data []byte, associatedData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return nil, err
@@ -65,7 +65,7 @@ func (e *AESEncryptionBackend) Encrypt(data []byte) ([]byte, error) {
 	}
 
 	// Seal the plaintext using the AES GCM cipher
-	encryptedData := aesgcm.Seal(nil, nonce, data, nil)
+	encryptedData := aesgcm.Seal(nil, nonce, data, associatedData)
 
 	// Prepend the nonce to the encrypted data
 	encryptedData = append(nonce, encryptedData...)
@@ -74,12 +74,16 @@ func (e *AESEncryptionBackend) Encrypt(data []byte) ([]byte, error) {
 }
 
 // Decrypt decrypts the provided encrypted data using AES decryption.
-func (e *AESEncryptionBackend) Decrypt(encryptedData []byte) ([]byte, error) {
+func (e *AESEncryptionBackend) Decrypt(encryptedData []byte, associatedData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(encryptedData) < 12 {
+		return nil, errors.New("invalid AES encrypted data length")
+	}
+
 	// Split the encrypted data into the nonce and the actual ciphertext
 	nonce := encryptedData[:12]
 	ciphertext := encryptedData[12:]
@@ -91,7 +95,7 @@ func (e *AESEncryptionBackend) Decrypt(encryptedData []byte) ([]byte, error) {
 	}
 
 	// Decrypt the ciphertext using the AES GCM cipher
-	decryptedData, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+	decryptedData, err := aesgcm.Open(nil, nonce, ciphertext, associatedData)
 	if err != nil {
 		return nil, err
 	}
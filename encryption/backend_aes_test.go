@@ -19,13 +19,15 @@ func TestAESEncryptionBackend(t *testing.T) {
 		t.Fatalf("Failed to initialize encryption backend: %v", err)
 	}
 
+	associatedData := []byte("etc/passwd")
+
 	// Test small file encryption and decryption
 	smallData := []byte("This is a small file.")
-	encryptedSmallData, err := backend.Encrypt(smallData)
+	encryptedSmallData, err := backend.Encrypt(smallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt small file: %v", err)
 	}
-	decryptedSmallData, err := backend.Decrypt(encryptedSmallData)
+	decryptedSmallData, err := backend.Decrypt(encryptedSmallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt small file: %v", err)
 	}
@@ -33,16 +35,21 @@ func TestAESEncryptionBackend(t *testing.T) {
 		t.Fatal("Small file encryption and decryption failed: data mismatch")
 	}
 
+	// Decryption must fail with different associated data
+	if _, err := backend.Decrypt(encryptedSmallData, []byte("other/file")); err == nil {
+		t.Fatal("Small file was decrypted with mismatching associated data")
+	}
+
 	// Test large file encryption and decryption
 	largeData := make([]byte, 10*1024*1024) // 1 MB
 	if _, err := rand.Read(largeData); err != nil {
 		t.Fatalf("Failed to generate random data for large file: %v", err)
 	}
-	encryptedLargeData, err := backend.Encrypt(largeData)
+	encryptedLargeData, err := backend.Encrypt(largeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt large file: %v", err)
 	}
-	decryptedLargeData, err := backend.Decrypt(encryptedLargeData)
+	decryptedLargeData, err := backend.Decrypt(encryptedLargeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt large file: %v", err)
 	}
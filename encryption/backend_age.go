@@ -0,0 +1,322 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// AgeEncryptionConfig represents the configuration for an age encryption backend.
+type AgeEncryptionConfig struct {
+	// Recipients is the content of a recipients file: one X25519 ("age1...")
+	// or SSH ("ssh-ed25519 ...", "ssh-rsa ...") public key per line.
+	Recipients string
+	// Identities is the content of an identity file: either age secret keys
+	// ("AGE-SECRET-KEY-1...") or an unencrypted SSH private key.
+	Identities string
+	// Passphrase enables scrypt passphrase encryption instead of recipients.
+	Passphrase string
+}
+
+// AgeEncryptionBackend represents an encryption backend producing standard age files.
+//
+// age has no associated data. It is bound by an extra "go-safe-ad" stanza
+// holding its digest, which is authenticated by the header MAC and ignored by
+// the stock age tool. An scrypt stanza must be alone in its file, so with a
+// passphrase the digest is folded into the scrypt salt instead.
+type AgeEncryptionBackend struct {
+	recipients []age.Recipient
+	identities []age.Identity
+	passphrase []byte
+}
+
+// NewAgeEncryptionBackend creates a new age encryption backend.
+func NewAgeEncryptionBackend(recipients string, identities string, passphrase string) (*AgeEncryptionBackend, error) {
+	b := AgeEncryptionBackend{}
+	err := b.Initialize(&AgeEncryptionConfig{
+		Recipients: recipients,
+		Identities: identities,
+		Passphrase: passphrase,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Initialize initializes the age encryption backend with the recipients and identities.
+func (e *AgeEncryptionBackend) Initialize(cfg EncryptionConfig) error {
+	// Check the configuration type
+	config, ok := cfg.(*AgeEncryptionConfig)
+	if !ok {
+		return errors.New("invalid age encryption configuration")
+	}
+
+	// An scrypt recipient must be the only recipient of a file
+	if config.Passphrase != "" {
+		if strings.TrimSpace(config.Recipients) != "" {
+			return errors.New("age passphrase and recipients are mutually exclusive")
+		}
+
+		identity, err := age.NewScryptIdentity(config.Passphrase)
+		if err != nil {
+			return err
+		}
+
+		e.passphrase = []byte(config.Passphrase)
+		e.identities = append(e.identities, identity)
+	} else {
+		recipients, err := parseAgeRecipients(config.Recipients)
+		if err != nil {
+			return err
+		}
+		e.recipients = recipients
+	}
+
+	identities, err := parseAgeIdentities(config.Identities)
+	if err != nil {
+		return err
+	}
+	e.identities = append(e.identities, identities...)
+
+	return nil
+}
+
+// parseAgeRecipients parses a recipients file, ignoring empty lines and comments.
+func parseAgeRecipients(content string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var recipient age.Recipient
+		var err error
+		if strings.HasPrefix(line, "ssh-") {
+			recipient, err = agessh.ParseRecipient(line)
+		} else {
+			recipient, err = age.ParseX25519Recipient(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient at line %d: %v", n, err)
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, scanner.Err()
+}
+
+// parseAgeIdentities parses an age identity file or an SSH private key.
+func parseAgeIdentities(content string) ([]age.Identity, error) {
+	content = strings.Trim(content, "\r\n ")
+	if content == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(content, "-----BEGIN") {
+		identity, err := agessh.ParseIdentity([]byte(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SSH identity: %v", err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	return age.ParseIdentities(strings.NewReader(content))
+}
+
+// Encrypt encrypts the data to the configured recipients.
+func (e *AgeEncryptionBackend) Encrypt(data []byte, associatedData []byte) ([]byte, error) {
+	var recipients []age.Recipient
+	switch {
+	case e.passphrase != nil:
+		recipients = []age.Recipient{&ageScryptRecipient{
+			password:       e.passphrase,
+			associatedData: associatedData,
+		}}
+	case len(e.recipients) > 0:
+		recipients = append([]age.Recipient{}, e.recipients...)
+		if associatedData != nil {
+			recipients = append(recipients, &ageBindingRecipient{
+				associatedData: associatedData,
+			})
+		}
+	default:
+		return nil, errors.New("no age recipients configured")
+	}
+
+	out := new(bytes.Buffer)
+	w, err := age.Encrypt(out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	// Closing the writer flushes the last chunk
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts the data using the configured identities.
+func (e *AgeEncryptionBackend) Decrypt(data []byte, associatedData []byte) ([]byte, error) {
+	if len(e.identities) == 0 {
+		return nil, errors.New("no age identities configured")
+	}
+
+	identity := &ageBindingIdentity{
+		identities:     e.identities,
+		associatedData: associatedData,
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// ageBindingStanza is the type of the stanza binding the associated data.
+const ageBindingStanza = "go-safe-ad"
+
+// ageScryptLabel and ageScryptWorkFactor are the ones of age.ScryptRecipient.
+const (
+	ageScryptLabel      = "age-encryption.org/v1/scrypt"
+	ageScryptWorkFactor = 18
+)
+
+// ageBindingRecipient adds a stanza holding the digest of the associated data.
+type ageBindingRecipient struct {
+	associatedData []byte
+}
+
+func (r *ageBindingRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	sum := sha256.Sum256(r.associatedData)
+	return []*age.Stanza{{
+		Type: ageBindingStanza,
+		Args: []string{base64.RawStdEncoding.EncodeToString(sum[:])},
+	}}, nil
+}
+
+// ageScryptRecipient is age.ScryptRecipient, with the second half of the salt
+// derived from its first half and the associated data.
+type ageScryptRecipient struct {
+	password       []byte
+	associatedData []byte
+}
+
+func (r *ageScryptRecipient) Wrap(fileKey []byte) ([]*age.Stanza, error) {
+	stanzas, _, err := r.WrapWithLabels(fileKey)
+	return stanzas, err
+}
+
+// WrapWithLabels returns a random label, so that it can't be mixed with other
+// recipients, like age.ScryptRecipient.
+func (r *ageScryptRecipient) WrapWithLabels(fileKey []byte) ([]*age.Stanza, []string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt[:8]); err != nil {
+		return nil, nil, err
+	}
+	copy(salt[8:], ageScryptSaltBinding(salt[:8], r.associatedData))
+
+	key, err := scrypt.Key(r.password, append([]byte(ageScryptLabel), salt...), 1<<ageScryptWorkFactor, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The key is derived from a fresh salt, so the nonce can be fixed
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	stanza := &age.Stanza{
+		Type: "scrypt",
+		Args: []string{base64.RawStdEncoding.EncodeToString(salt), strconv.Itoa(ageScryptWorkFactor)},
+		Body: aead.Seal(nil, nonce, fileKey, nil),
+	}
+
+	label := make([]byte, 16)
+	if _, err := rand.Read(label); err != nil {
+		return nil, nil, err
+	}
+
+	return []*age.Stanza{stanza}, []string{hex.EncodeToString(label)}, nil
+}
+
+// ageScryptSaltBinding returns the half of the scrypt salt binding the associated data.
+func ageScryptSaltBinding(random []byte, associatedData []byte) []byte {
+	h := sha256.New()
+	h.Write(random)
+	h.Write(associatedData)
+	return h.Sum(nil)[:8]
+}
+
+// ageBindingIdentity checks the associated data bound to a file before
+// unwrapping it with the underlying identities. The stanzas are covered by the
+// header MAC, which age checks with the unwrapped file key.
+type ageBindingIdentity struct {
+	identities     []age.Identity
+	associatedData []byte
+}
+
+func (i *ageBindingIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	var filtered []*age.Stanza
+	bound := false
+	for _, stanza := range stanzas {
+		switch stanza.Type {
+		case ageBindingStanza:
+			sum := sha256.Sum256(i.associatedData)
+			if i.associatedData == nil || len(stanza.Args) != 1 || stanza.Args[0] != base64.RawStdEncoding.EncodeToString(sum[:]) {
+				return nil, errors.New("age associated data mismatch")
+			}
+			bound = true
+			continue
+		case "scrypt":
+			if i.associatedData != nil && len(stanza.Args) == 2 {
+				salt, err := base64.RawStdEncoding.DecodeString(stanza.Args[0])
+				if err != nil || len(salt) != 16 || !hmac.Equal(salt[8:], ageScryptSaltBinding(salt[:8], i.associatedData)) {
+					return nil, errors.New("age associated data mismatch")
+				}
+				bound = true
+			}
+		}
+		filtered = append(filtered, stanza)
+	}
+	if i.associatedData != nil && !bound {
+		return nil, errors.New("age associated data mismatch")
+	}
+
+	for _, identity := range i.identities {
+		fileKey, err := identity.Unwrap(filtered)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		return fileKey, err
+	}
+
+	return nil, age.ErrIncorrectIdentity
+}
@@ -0,0 +1,159 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAgeEncryptionBackend(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	sshPub, sshPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate SSH key: %v", err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(sshPub)
+	if err != nil {
+		t.Fatalf("Failed to convert SSH public key: %v", err)
+	}
+	sshPrivateKey, err := ssh.MarshalPrivateKey(sshPriv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal SSH private key: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		recipients string
+		identities string
+		passphrase string
+	}{
+		{
+			name:       "X25519",
+			recipients: "# public key\n" + identity.Recipient().String() + "\n",
+			identities: identity.String(),
+		},
+		{
+			name:       "Passphrase",
+			passphrase: "correct horse battery staple",
+		},
+		{
+			name:       "SSH",
+			recipients: string(ssh.MarshalAuthorizedKey(sshPublicKey)),
+			identities: string(pem.EncodeToMemory(sshPrivateKey)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Initialize the encryption backend
+			backend, err := NewAgeEncryptionBackend(tt.recipients, tt.identities, tt.passphrase)
+			if err != nil {
+				t.Fatalf("Failed to initialize encryption backend: %v", err)
+			}
+
+			associatedData := []byte("etc/passwd")
+
+			// Test small file encryption and decryption
+			smallData := []byte("This is a small file.")
+			encryptedSmallData, err := backend.Encrypt(smallData, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to encrypt small file: %v", err)
+			}
+			if !bytes.HasPrefix(encryptedSmallData, []byte("age-encryption.org/v1\n")) {
+				t.Fatal("Encrypted data is not a standard age file")
+			}
+			decryptedSmallData, err := backend.Decrypt(encryptedSmallData, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to decrypt small file: %v", err)
+			}
+			if !bytes.Equal(smallData, decryptedSmallData) {
+				t.Fatal("Small file encryption and decryption failed: data mismatch")
+			}
+
+			// Decryption must fail with different associated data
+			if _, err := backend.Decrypt(encryptedSmallData, []byte("other/file")); err == nil {
+				t.Fatal("Small file was decrypted with mismatching associated data")
+			}
+
+			// Test large file encryption and decryption
+			largeData := make([]byte, 10*1024*1024) // 10 MB
+			if _, err := rand.Read(largeData); err != nil {
+				t.Fatalf("Failed to generate random data for large file: %v", err)
+			}
+			encryptedLargeData, err := backend.Encrypt(largeData, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to encrypt large file: %v", err)
+			}
+			decryptedLargeData, err := backend.Decrypt(encryptedLargeData, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to decrypt large file: %v", err)
+			}
+			if !bytes.Equal(largeData, decryptedLargeData) {
+				t.Fatal("Large file encryption and decryption failed: data mismatch")
+			}
+		})
+	}
+}
+
+func TestAgeEncryptionBackendStockCompatibility(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	passphrase := "correct horse battery staple"
+	scryptIdentity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		t.Fatalf("Failed to create scrypt identity: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		recipients string
+		passphrase string
+		identity   age.Identity
+	}{
+		{"X25519", identity.Recipient().String(), "", identity},
+		{"Passphrase", "", passphrase, scryptIdentity},
+	}
+
+	data := []byte("This is a small file.")
+	associatedData := []byte("etc/passwd")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewAgeEncryptionBackend(tt.recipients, identity.String(), tt.passphrase)
+			if err != nil {
+				t.Fatalf("Failed to initialize encryption backend: %v", err)
+			}
+
+			// Bound files must still be readable by the stock age tool
+			encrypted, err := backend.Encrypt(data, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to encrypt file: %v", err)
+			}
+			r, err := age.Decrypt(bytes.NewReader(encrypted), tt.identity)
+			if err != nil {
+				t.Fatalf("Failed to decrypt file with age: %v", err)
+			}
+			decrypted, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(data, decrypted) {
+				t.Fatal("File decryption with age failed: data mismatch")
+			}
+
+			// A bound file can't be read as an unbound one
+			if _, err := backend.Decrypt(encrypted, nil); err == nil && tt.passphrase == "" {
+				t.Fatal("Bound file was decrypted without associated data")
+			}
+		})
+	}
+}
@@ -1,11 +1,15 @@
 package encryption
 
 import (
+	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
 
 	ecies "github.com/yyewolf/go-ecies/v2"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 // EciesEncryptionConfig represents the configuration for an ECIES encryption backend.
@@ -62,12 +66,96 @@ func (e *EciesEncryptionBackend) Initialize(cfg EncryptionConfig) error {
 	return nil
 }
 
+// eciesMinPlaintextSize is the size of the smallest plaintext the layout of
+// ecies.Encrypt can hold.
+const eciesMinPlaintextSize = chacha20poly1305.NonceSizeX - chacha20poly1305.Overhead
+
 // Encrypt encrypts the data using ECIES.
-func (e *EciesEncryptionBackend) Encrypt(data []byte) ([]byte, error) {
-	return ecies.Encrypt(e.publicKey, data)
+//
+// The layout is the one of ecies.Encrypt, which has no associated data:
+// ephemeral public key, nonce, the last 24 bytes of the sealed data, then the
+// rest of the sealed data.
+func (e *EciesEncryptionBackend) Encrypt(data []byte, associatedData []byte) ([]byte, error) {
+	if e.publicKey == nil {
+		return nil, errors.New("no ECIES public key configured")
+	}
+
+	// The layout needs at least 24 bytes of sealed data, of which 16 are the tag
+	if len(data) < eciesMinPlaintextSize {
+		return nil, fmt.Errorf("ECIES can't encrypt less than %d bytes, got %d", eciesMinPlaintextSize, len(data))
+	}
+
+	// Generate ephemeral key
+	ephemeralKey, err := ecies.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive shared secret
+	sharedSecret, err := ephemeralKey.Encapsulate(e.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nil, nonce, data, associatedData)
+	tag := sealed[len(sealed)-aead.NonceSize():]
+
+	encryptedData := new(bytes.Buffer)
+	encryptedData.Write(ephemeralKey.PublicKey.Bytes())
+	encryptedData.Write(nonce)
+	encryptedData.Write(tag)
+	encryptedData.Write(sealed[:len(sealed)-len(tag)])
+
+	return encryptedData.Bytes(), nil
 }
 
 // Decrypt decrypts the data using ECIES.
-func (e *EciesEncryptionBackend) Decrypt(data []byte) ([]byte, error) {
-	return ecies.Decrypt(e.privateKey, data)
+func (e *EciesEncryptionBackend) Decrypt(data []byte, associatedData []byte) ([]byte, error) {
+	if e.privateKey == nil {
+		return nil, errors.New("no ECIES private key configured")
+	}
+
+	// Ephemeral public key, nonce and the shifted tag
+	publicKeySize := len(e.privateKey.PublicKey.Bytes())
+	if len(data) < publicKeySize+2*chacha20poly1305.NonceSizeX {
+		return nil, errors.New("invalid ECIES encrypted data length")
+	}
+
+	coordinateSize := (publicKeySize - 1) / 2
+	ephemeralKey := &ecies.PublicKey{
+		Curve: e.privateKey.PublicKey.Curve,
+		X:     new(big.Int).SetBytes(data[1 : 1+coordinateSize]),
+		Y:     new(big.Int).SetBytes(data[1+coordinateSize : publicKeySize]),
+	}
+	if !ephemeralKey.Curve.IsOnCurve(ephemeralKey.X, ephemeralKey.Y) {
+		return nil, errors.New("invalid ECIES ephemeral public key")
+	}
+	data = data[publicKeySize:]
+
+	// Derive shared secret
+	sharedSecret, err := ephemeralKey.Decapsulate(e.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := data[:aead.NonceSize()]
+	tag := data[aead.NonceSize() : 2*aead.NonceSize()]
+	sealed := append(append([]byte{}, data[2*aead.NonceSize():]...), tag...)
+
+	return aead.Open(nil, nonce, sealed, associatedData)
 }
@@ -21,14 +21,16 @@ func TestECIESEncryptionBackend(t *testing.T) {
 		t.Fatalf("Failed to initialize encryption backend: %v", err)
 	}
 
+	associatedData := []byte("etc/passwd")
+
 	// Test small file encryption and decryption
 	smallData := []byte("This is a small file.")
-	encryptedSmallData, err := backend.Encrypt(smallData)
+	encryptedSmallData, err := backend.Encrypt(smallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt small file: %v", err)
 	}
 
-	decryptedSmallData, err := backend.Decrypt(encryptedSmallData)
+	decryptedSmallData, err := backend.Decrypt(encryptedSmallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt small file: %v", err)
 	}
@@ -36,16 +38,36 @@ func TestECIESEncryptionBackend(t *testing.T) {
 		t.Fatal("Small file encryption and decryption failed: data mismatch")
 	}
 
+	// Decryption must fail with different associated data
+	if _, err := backend.Decrypt(encryptedSmallData, []byte("other/file")); err == nil {
+		t.Fatal("Small file was decrypted with mismatching associated data")
+	}
+
+	// Plaintexts too short for the layout are refused instead of panicking
+	for size := 0; size < eciesMinPlaintextSize; size++ {
+		if _, err := backend.Encrypt(make([]byte, size), associatedData); err == nil {
+			t.Fatalf("Expected an error encrypting %d bytes", size)
+		}
+	}
+	minData := make([]byte, eciesMinPlaintextSize)
+	encryptedMinData, err := backend.Encrypt(minData, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to encrypt %d bytes: %v", len(minData), err)
+	}
+	if decrypted, err := backend.Decrypt(encryptedMinData, associatedData); err != nil || !bytes.Equal(minData, decrypted) {
+		t.Fatalf("Failed to decrypt %d bytes: %v", len(minData), err)
+	}
+
 	// Test large file encryption and decryption
 	largeData := make([]byte, 10*1024*1024) // 1 MB
 	if _, err := rand.Read(largeData); err != nil {
 		t.Fatalf("Failed to generate random data for large file: %v", err)
 	}
-	encryptedLargeData, err := backend.Encrypt(largeData)
+	encryptedLargeData, err := backend.Encrypt(largeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt large file: %v", err)
 	}
-	decryptedLargeData, err := backend.Decrypt(encryptedLargeData)
+	decryptedLargeData, err := backend.Decrypt(encryptedLargeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt large file: %v", err)
 	}
@@ -53,3 +75,42 @@ func TestECIESEncryptionBackend(t *testing.T) {
 		t.Fatal("Large file encryption and decryption failed: data mismatch")
 	}
 }
+
+func TestECIESEncryptionBackendLegacyCiphertext(t *testing.T) {
+	priv, err := ecies.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate ECIES key: %v", err)
+	}
+
+	backend, err := NewEciesEncryptionBackend(priv.PublicKey.Hex(), priv.Hex())
+	if err != nil {
+		t.Fatalf("Failed to initialize encryption backend: %v", err)
+	}
+
+	// Objects used to be encrypted by the ECIES library directly
+	data := []byte("This is a legacy file.")
+	legacy, err := ecies.Encrypt(priv.PublicKey, data)
+	if err != nil {
+		t.Fatalf("Failed to encrypt legacy file: %v", err)
+	}
+	decrypted, err := backend.Decrypt(legacy, nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt legacy file: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Fatal("Legacy file decryption failed: data mismatch")
+	}
+
+	// Without associated data, the output is still readable by the library
+	encrypted, err := backend.Encrypt(data, nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+	decrypted, err = ecies.Decrypt(priv, encrypted)
+	if err != nil {
+		t.Fatalf("Failed to decrypt file with the ECIES library: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Fatal("File decryption with the ECIES library failed: data mismatch")
+	}
+}
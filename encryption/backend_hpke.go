@@ -1,12 +1,78 @@
 package encryption
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 
-	hpke "github.com/jedisct1/go-hpke-compact"
+	"github.com/cloudflare/circl/hpke"
+	"github.com/cloudflare/circl/kem"
 )
 
+// HPKEMode represents the HPKE mode used to set up the encryption context.
+type HPKEMode string
+
+const (
+	// HPKEModeBase encrypts to the server public key only.
+	HPKEModeBase HPKEMode = "base"
+	// HPKEModePSK additionally binds the ciphertext to a preshared key.
+	HPKEModePSK HPKEMode = "psk"
+	// HPKEModeAuth additionally authenticates the client secret key.
+	HPKEModeAuth HPKEMode = "auth"
+	// HPKEModeAuthPSK combines the PSK and Auth modes.
+	HPKEModeAuthPSK HPKEMode = "auth-psk"
+)
+
+// hpkeModeIDs maps the modes to their RFC 9180 identifiers.
+var hpkeModeIDs = map[HPKEMode]uint8{
+	HPKEModeBase:    0x00,
+	HPKEModePSK:     0x01,
+	HPKEModeAuth:    0x02,
+	HPKEModeAuthPSK: 0x03,
+}
+
+// hpkeKEMs, hpkeKDFs and hpkeAEADs map the suite names accepted in the configuration.
+var (
+	hpkeKEMs = map[string]hpke.KEM{
+		"x25519": hpke.KEM_X25519_HKDF_SHA256,
+		"x448":   hpke.KEM_X448_HKDF_SHA512,
+		"p256":   hpke.KEM_P256_HKDF_SHA256,
+		"p384":   hpke.KEM_P384_HKDF_SHA384,
+		"p521":   hpke.KEM_P521_HKDF_SHA512,
+	}
+	hpkeKDFs = map[string]hpke.KDF{
+		"sha256": hpke.KDF_HKDF_SHA256,
+		"sha384": hpke.KDF_HKDF_SHA384,
+		"sha512": hpke.KDF_HKDF_SHA512,
+	}
+	hpkeAEADs = map[string]hpke.AEAD{
+		"aes128gcm":        hpke.AEAD_AES128GCM,
+		"aes256gcm":        hpke.AEAD_AES256GCM,
+		"chacha20poly1305": hpke.AEAD_ChaCha20Poly1305,
+	}
+)
+
+// hpkeInfo is the application info bound to every HPKE context.
+var hpkeInfo = []byte("go-safe")
+
+const (
+	// hpkeVersion is the version of the binary ciphertext framing.
+	hpkeVersion = 1
+	// hpkeHeaderSize is the size of the header, up to the encapsulated key.
+	hpkeHeaderSize = 18
+	// hpkeChunkSize is the size of the plaintext chunks sealed one by one.
+	hpkeChunkSize = 64 * 1024
+)
+
+// hpkeMagic starts every binary HPKE ciphertext.
+var hpkeMagic = []byte("GSHP")
+
+// HPKEConfig represents the configuration for an HPKE encryption backend.
 type HPKEConfig struct {
 	UtilityPKey []byte
 	UtilitySKey []byte
@@ -15,14 +81,49 @@ type HPKEConfig struct {
 
 	PresharedKey   []byte
 	PresharedKeyID []byte
+
+	// KEM, KDF and AEAD select the suite used to encrypt, defaulting to
+	// X25519, HKDF-SHA256 and ChaCha20-Poly1305.
+	KEM  hpke.KEM
+	KDF  hpke.KDF
+	AEAD hpke.AEAD
+
+	// Mode selects the HPKE mode used to encrypt. When empty, it is deduced
+	// from the keys available: auth if a client secret key is set, psk if a
+	// preshared key is set, both or none of them.
+	Mode HPKEMode
 }
 
-// HPKEBackend is an implementation of the EncryptionBackend interface using go-hpke-compact.
+// HPKEBackend is an implementation of the EncryptionBackend interface using HPKE (RFC 9180).
 type HPKEBackend struct {
-	suite     *hpke.Suite
-	client    hpke.KeyPair
-	server    hpke.KeyPair
-	preshared *hpke.Psk
+	suite     hpke.Suite
+	mode      HPKEMode
+	client    hpkeKeyPair
+	server    hpkeKeyPair
+	preshared hpkePSK
+}
+
+type hpkeKeyPair struct {
+	PublicKey []byte
+	SecretKey []byte
+}
+
+type hpkePSK struct {
+	Key []byte
+	ID  []byte
+}
+
+// hpkeCiphertext is the legacy JSON form of an HPKE encrypted object.
+type hpkeCiphertext struct {
+	EncryptedData []byte `json:"ed"`
+	SharedSecret  []byte `json:"ss"`
+
+	// The suite and mode are left empty by the oldest ciphertexts, which
+	// were always X25519/HKDF-SHA256/ChaCha20-Poly1305 in auth-psk mode.
+	KEM  hpke.KEM  `json:"k,omitempty"`
+	KDF  hpke.KDF  `json:"f,omitempty"`
+	AEAD hpke.AEAD `json:"a,omitempty"`
+	Mode HPKEMode  `json:"m,omitempty"`
 }
 
 func NewHPKEBackend(clientPublicKey, clientSecretKey, serverPublicKey, serverPrivateKey, presharedKey, presharedKeyID []byte) (*HPKEBackend, error) {
@@ -46,24 +147,81 @@ func NewHPKEBackend(clientPublicKey, clientSecretKey, serverPublicKey, serverPri
 	return b, nil
 }
 
+// ParseHPKESuite parses KEM, KDF and AEAD names (e.g. "p256", "sha256", "aes256gcm").
+// Empty names select the default algorithm.
+func ParseHPKESuite(kemName, kdfName, aeadName string) (hpke.KEM, hpke.KDF, hpke.AEAD, error) {
+	var kemID hpke.KEM
+	var kdfID hpke.KDF
+	var aeadID hpke.AEAD
+	var ok bool
+
+	if kemName != "" {
+		if kemID, ok = hpkeKEMs[strings.ToLower(kemName)]; !ok {
+			return 0, 0, 0, fmt.Errorf("unknown HPKE KEM %q", kemName)
+		}
+	}
+	if kdfName != "" {
+		if kdfID, ok = hpkeKDFs[strings.ToLower(kdfName)]; !ok {
+			return 0, 0, 0, fmt.Errorf("unknown HPKE KDF %q", kdfName)
+		}
+	}
+	if aeadName != "" {
+		if aeadID, ok = hpkeAEADs[strings.ToLower(aeadName)]; !ok {
+			return 0, 0, 0, fmt.Errorf("unknown HPKE AEAD %q", aeadName)
+		}
+	}
+
+	return kemID, kdfID, aeadID, nil
+}
+
 // Initialize initializes the encryption backend with the provided configuration.
 func (b *HPKEBackend) Initialize(config EncryptionConfig) error {
 	cfg, ok := config.(*HPKEConfig)
 	if !ok {
-		return errors.New("invalid GoHPKECompact encryption configuration")
+		return errors.New("invalid HPKE encryption configuration")
 	}
 
-	suite, err := hpke.NewSuite(hpke.KemX25519HkdfSha256, hpke.KdfHkdfSha256, hpke.AeadChaCha20Poly1305)
-	if err != nil {
-		return err
+	kemID, kdfID, aeadID := cfg.KEM, cfg.KDF, cfg.AEAD
+	if kemID == 0 {
+		kemID = hpke.KEM_X25519_HKDF_SHA256
+	}
+	if kdfID == 0 {
+		kdfID = hpke.KDF_HKDF_SHA256
+	}
+	if aeadID == 0 {
+		aeadID = hpke.AEAD_ChaCha20Poly1305
+	}
+	if !kemID.IsValid() || !kdfID.IsValid() || !aeadID.IsValid() {
+		return errors.New("invalid HPKE suite")
 	}
 
-	b.suite = suite
+	mode := cfg.Mode
+	if mode == "" {
+		switch {
+		case len(cfg.UtilitySKey) > 0 && len(cfg.PresharedKey) > 0:
+			mode = HPKEModeAuthPSK
+		case len(cfg.UtilitySKey) > 0:
+			mode = HPKEModeAuth
+		case len(cfg.PresharedKey) > 0:
+			mode = HPKEModePSK
+		default:
+			mode = HPKEModeBase
+		}
+	}
+	if _, ok := hpkeModeIDs[mode]; !ok {
+		return fmt.Errorf("unknown HPKE mode %q", mode)
+	}
+	if (mode == HPKEModePSK || mode == HPKEModeAuthPSK) && (len(cfg.PresharedKey) == 0 || len(cfg.PresharedKeyID) == 0) {
+		return fmt.Errorf("HPKE mode %s requires a preshared key and its ID", mode)
+	}
+
+	b.suite = hpke.NewSuite(kemID, kdfID, aeadID)
+	b.mode = mode
 	b.client.PublicKey = cfg.UtilityPKey
 	b.client.SecretKey = cfg.UtilitySKey
 	b.server.PublicKey = cfg.YourPKey
 	b.server.SecretKey = cfg.YourSKey
-	b.preshared = &hpke.Psk{
+	b.preshared = hpkePSK{
 		Key: cfg.PresharedKey,
 		ID:  cfg.PresharedKeyID,
 	}
@@ -72,44 +230,343 @@ func (b *HPKEBackend) Initialize(config EncryptionConfig) error {
 }
 
 // Encrypt encrypts the provided plaintext.
-func (b *HPKEBackend) Encrypt(plaintext []byte) ([]byte, error) {
-	clientCtx, ss, err := b.suite.NewAuthenticatedClientContext(b.client, b.server.PublicKey, []byte("go-safe"), b.preshared)
-	if err != nil {
+func (b *HPKEBackend) Encrypt(plaintext []byte, associatedData []byte) ([]byte, error) {
+	out := new(bytes.Buffer)
+	if err := b.encryptChunks(out, bytes.NewReader(plaintext), associatedData); err != nil {
 		return nil, err
 	}
+	return out.Bytes(), nil
+}
 
-	ciphertext, err := clientCtx.EncryptToServer(plaintext, nil)
-	if err != nil {
+// Decrypt decrypts the provided ciphertext, using the suite and mode it records.
+func (b *HPKEBackend) Decrypt(ciphertext []byte, associatedData []byte) ([]byte, error) {
+	// Ciphertexts used to be JSON objects
+	if len(ciphertext) > 0 && ciphertext[0] == '{' {
+		return b.decryptJSON(ciphertext, associatedData)
+	}
+
+	out := new(bytes.Buffer)
+	if err := b.decryptChunks(out, bytes.NewReader(ciphertext), associatedData); err != nil {
 		return nil, err
 	}
+	return out.Bytes(), nil
+}
+
+// encryptChunks encrypts src into dst, chunk by chunk. The layout is:
+//
+//	magic "GSHP" | version | kem (2) | kdf (2) | aead (2) | mode | chunk size (4) | enc length (2) | enc
+//
+// followed by chunks, each one being a final flag, a 4 bytes length and the
+// sealed chunk. Chunks are sealed in order with the context sequence number,
+// and authenticate the header and their final flag, so that reordering,
+// truncation and header tampering are all detected. The associated data is
+// authenticated along with every chunk.
+func (b *HPKEBackend) encryptChunks(dst io.Writer, src io.Reader, associatedData []byte) error {
+	enc, sealer, err := b.setupSealer()
+	if err != nil {
+		return err
+	}
+
+	kemID, kdfID, aeadID := b.suite.Params()
+	header := make([]byte, 0, hpkeHeaderSize+len(enc))
+	header = append(header, hpkeMagic...)
+	header = append(header, hpkeVersion)
+	header = binary.BigEndian.AppendUint16(header, uint16(kemID))
+	header = binary.BigEndian.AppendUint16(header, uint16(kdfID))
+	header = binary.BigEndian.AppendUint16(header, uint16(aeadID))
+	header = append(header, hpkeModeIDs[b.mode])
+	header = binary.BigEndian.AppendUint32(header, hpkeChunkSize)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(enc)))
+	header = append(header, enc...)
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
 
-	out := struct {
-		EncryptedData []byte `json:"ed"`
-		SharedSecret  []byte `json:"ss"`
-	}{
-		EncryptedData: ciphertext,
-		SharedSecret:  ss,
+	// Read one chunk ahead to know which one is the last
+	chunk := make([]byte, hpkeChunkSize)
+	next := make([]byte, hpkeChunkSize)
+	n, err := io.ReadFull(src, chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
 	}
 
-	return json.Marshal(out)
+	for {
+		final := n < hpkeChunkSize
+		m := 0
+		if !final {
+			m, err = io.ReadFull(src, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			final = m == 0
+		}
+
+		ciphertext, err := sealer.Seal(chunk[:n], hpkeChunkAAD(header, final, associatedData))
+		if err != nil {
+			return err
+		}
+
+		frame := make([]byte, 0, 5)
+		frame = append(frame, hpkeChunkFlag(final))
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(ciphertext)))
+		if _, err := dst.Write(frame); err != nil {
+			return err
+		}
+		if _, err := dst.Write(ciphertext); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+		chunk, next, n = next, chunk, m
+	}
 }
 
-// Decrypt decrypts the provided ciphertext.
-func (b *HPKEBackend) Decrypt(ciphertext []byte) ([]byte, error) {
-	var in struct {
-		EncryptedData []byte `json:"ed"`
-		SharedSecret  []byte `json:"ss"`
+// decryptChunks decrypts a ciphertext produced by encryptChunks from src into dst.
+func (b *HPKEBackend) decryptChunks(dst io.Writer, src io.Reader, associatedData []byte) error {
+	fixed := make([]byte, hpkeHeaderSize)
+	if _, err := io.ReadFull(src, fixed); err != nil {
+		return errors.New("invalid HPKE ciphertext: truncated header")
+	}
+	if !bytes.Equal(fixed[:len(hpkeMagic)], hpkeMagic) {
+		return errors.New("invalid HPKE ciphertext: bad magic")
+	}
+	if fixed[4] != hpkeVersion {
+		return fmt.Errorf("unsupported HPKE ciphertext version %d", fixed[4])
+	}
+
+	kemID := hpke.KEM(binary.BigEndian.Uint16(fixed[5:7]))
+	kdfID := hpke.KDF(binary.BigEndian.Uint16(fixed[7:9]))
+	aeadID := hpke.AEAD(binary.BigEndian.Uint16(fixed[9:11]))
+	mode, err := hpkeModeFromID(fixed[11])
+	if err != nil {
+		return err
+	}
+	// Chunks are always sealed at the same size, anything else was tampered with
+	if chunkSize := binary.BigEndian.Uint32(fixed[12:16]); chunkSize != hpkeChunkSize {
+		return fmt.Errorf("invalid HPKE ciphertext: unsupported chunk size %d", chunkSize)
+	}
+	encLength := binary.BigEndian.Uint16(fixed[16:18])
+
+	enc := make([]byte, encLength)
+	if _, err := io.ReadFull(src, enc); err != nil {
+		return errors.New("invalid HPKE ciphertext: truncated header")
+	}
+	header := append(fixed, enc...)
+
+	opener, err := b.setupOpener(kemID, kdfID, aeadID, mode, enc)
+	if err != nil {
+		return err
+	}
+
+	maxLength := aeadID.CipherLen(hpkeChunkSize)
+	frame := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(src, frame); err != nil {
+			return errors.New("invalid HPKE ciphertext: truncated")
+		}
+		if frame[0] != hpkeChunkFlag(false) && frame[0] != hpkeChunkFlag(true) {
+			return errors.New("invalid HPKE ciphertext: bad chunk flag")
+		}
+		final := frame[0] == hpkeChunkFlag(true)
+		length := binary.BigEndian.Uint32(frame[1:])
+		if uint(length) > maxLength {
+			return errors.New("invalid HPKE ciphertext: chunk too large")
+		}
+
+		ciphertext := make([]byte, length)
+		if _, err := io.ReadFull(src, ciphertext); err != nil {
+			return errors.New("invalid HPKE ciphertext: truncated")
+		}
+
+		plaintext, err := opener.Open(ciphertext, hpkeChunkAAD(header, final, associatedData))
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return err
+		}
+
+		if final {
+			break
+		}
+	}
+
+	// Nothing may follow the final chunk
+	if n, _ := src.Read(frame[:1]); n != 0 {
+		return errors.New("invalid HPKE ciphertext: trailing data")
 	}
 
+	return nil
+}
+
+// decryptJSON decrypts the legacy JSON form of the ciphertexts.
+func (b *HPKEBackend) decryptJSON(ciphertext []byte, associatedData []byte) ([]byte, error) {
+	var in hpkeCiphertext
+
 	err := json.Unmarshal(ciphertext, &in)
 	if err != nil {
 		return nil, err
 	}
 
-	serverCtx, err := b.suite.NewAuthenticatedServerContext(b.client.PublicKey, in.SharedSecret, b.server, []byte("go-safe"), b.preshared)
+	// Legacy ciphertexts always used auth-psk, with a possibly empty preshared key
+	if in.KEM == 0 && in.KDF == 0 && in.AEAD == 0 && in.Mode == "" {
+		in.KEM = hpke.KEM_X25519_HKDF_SHA256
+		in.KDF = hpke.KDF_HKDF_SHA256
+		in.AEAD = hpke.AEAD_ChaCha20Poly1305
+		in.Mode = HPKEModeAuthPSK
+	}
+
+	opener, err := b.setupOpener(in.KEM, in.KDF, in.AEAD, in.Mode, in.SharedSecret)
 	if err != nil {
 		return nil, err
 	}
 
-	return serverCtx.DecryptFromClient(in.EncryptedData, nil)
+	return opener.Open(in.EncryptedData, associatedData)
+}
+
+// setupSealer creates the sender context for the configured suite and mode.
+func (b *HPKEBackend) setupSealer() ([]byte, hpke.Sealer, error) {
+	kemID, _, _ := b.suite.Params()
+	scheme := kemID.Scheme()
+
+	serverPublicKey, err := scheme.UnmarshalBinaryPublicKey(b.server.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HPKE server public key: %v", err)
+	}
+
+	sender, err := b.suite.NewSender(serverPublicKey, hpkeInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var clientSecretKey kem.PrivateKey
+	if b.mode == HPKEModeAuth || b.mode == HPKEModeAuthPSK {
+		clientSecretKey, err = scheme.UnmarshalBinaryPrivateKey(b.client.SecretKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid HPKE client secret key: %v", err)
+		}
+	}
+
+	switch b.mode {
+	case HPKEModeBase:
+		return sender.Setup(rand.Reader)
+	case HPKEModePSK:
+		return sender.SetupPSK(rand.Reader, b.preshared.Key, b.preshared.ID)
+	case HPKEModeAuth:
+		return sender.SetupAuth(rand.Reader, clientSecretKey)
+	case HPKEModeAuthPSK:
+		return sender.SetupAuthPSK(rand.Reader, clientSecretKey, b.preshared.Key, b.preshared.ID)
+	}
+
+	return nil, nil, fmt.Errorf("unknown HPKE mode %q", b.mode)
+}
+
+// setupOpener creates the receiver context for the suite and mode recorded in a ciphertext.
+func (b *HPKEBackend) setupOpener(kemID hpke.KEM, kdfID hpke.KDF, aeadID hpke.AEAD, mode HPKEMode, enc []byte) (hpke.Opener, error) {
+	if !kemID.IsValid() || !kdfID.IsValid() || !aeadID.IsValid() {
+		return nil, errors.New("unsupported HPKE suite")
+	}
+
+	// The mode recorded in the ciphertext can't be weaker than what the keys
+	// configured to decrypt call for, else anyone knowing the server public
+	// key could forge ciphertexts in base mode
+	if len(b.client.PublicKey) > 0 && mode != HPKEModeAuth && mode != HPKEModeAuthPSK {
+		return nil, fmt.Errorf("HPKE ciphertext in %s mode isn't authenticated by the client key", mode)
+	}
+	if len(b.preshared.Key) > 0 && mode != HPKEModePSK && mode != HPKEModeAuthPSK {
+		return nil, fmt.Errorf("HPKE ciphertext in %s mode isn't bound to the preshared key", mode)
+	}
+
+	suite := hpke.NewSuite(kemID, kdfID, aeadID)
+	scheme := kemID.Scheme()
+
+	serverSecretKey, err := scheme.UnmarshalBinaryPrivateKey(b.server.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HPKE server secret key: %v", err)
+	}
+
+	receiver, err := suite.NewReceiver(serverSecretKey, hpkeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientPublicKey kem.PublicKey
+	if mode == HPKEModeAuth || mode == HPKEModeAuthPSK {
+		clientPublicKey, err = scheme.UnmarshalBinaryPublicKey(b.client.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HPKE client public key: %v", err)
+		}
+	}
+
+	// A nil preshared key is rejected in PSK modes, even if it was empty on encryption
+	psk := append([]byte{}, b.preshared.Key...)
+	pskID := append([]byte{}, b.preshared.ID...)
+
+	switch mode {
+	case HPKEModeBase:
+		return receiver.Setup(enc)
+	case HPKEModePSK:
+		return receiver.SetupPSK(enc, psk, pskID)
+	case HPKEModeAuth:
+		return receiver.SetupAuth(enc, clientPublicKey)
+	case HPKEModeAuthPSK:
+		return receiver.SetupAuthPSK(enc, psk, pskID, clientPublicKey)
+	}
+
+	return nil, fmt.Errorf("unknown HPKE mode %q", mode)
+}
+
+// hpkeModeFromID returns the mode matching an RFC 9180 identifier.
+func hpkeModeFromID(id uint8) (HPKEMode, error) {
+	for mode, modeID := range hpkeModeIDs {
+		if modeID == id {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown HPKE mode %d", id)
+}
+
+// hpkeChunkFlag returns the flag byte marking a chunk as final or not.
+func hpkeChunkFlag(final bool) byte {
+	if final {
+		return 1
+	}
+	return 0
+}
+
+// hpkeChunkAAD returns the associated data of a chunk.
+func hpkeChunkAAD(header []byte, final bool, associatedData []byte) []byte {
+	aad := make([]byte, 0, len(header)+1+len(associatedData))
+	aad = append(aad, header...)
+	aad = append(aad, hpkeChunkFlag(final))
+	return append(aad, associatedData...)
+}
+
+// GenerateHPKEKeyPair generates a key pair for the given KEM, defaulting to X25519.
+func GenerateHPKEKeyPair(kemID hpke.KEM) (publicKey []byte, secretKey []byte, err error) {
+	if kemID == 0 {
+		kemID = hpke.KEM_X25519_HKDF_SHA256
+	}
+	if !kemID.IsValid() {
+		return nil, nil, errors.New("invalid HPKE KEM")
+	}
+
+	pk, sk, err := kemID.Scheme().GenerateKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err = pk.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	secretKey, err = sk.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, secretKey, nil
 }
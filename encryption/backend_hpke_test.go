@@ -3,6 +3,8 @@ package encryption
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
 	"testing"
 
 	hpke "github.com/jedisct1/go-hpke-compact"
@@ -32,14 +34,16 @@ func TestHPKEBackend(t *testing.T) {
 		t.Fatalf("Failed to initialize encryption backend: %v", err)
 	}
 
+	associatedData := []byte("etc/passwd")
+
 	// Test small file encryption and decryption
 	smallData := []byte("This is a small file.")
-	encryptedSmallData, err := backend.Encrypt(smallData)
+	encryptedSmallData, err := backend.Encrypt(smallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt small file: %v", err)
 	}
 
-	decryptedSmallData, err := backend.Decrypt(encryptedSmallData)
+	decryptedSmallData, err := backend.Decrypt(encryptedSmallData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt small file: %v", err)
 	}
@@ -47,16 +51,21 @@ func TestHPKEBackend(t *testing.T) {
 		t.Fatal("Small file encryption and decryption failed: data mismatch")
 	}
 
+	// Decryption must fail with different associated data
+	if _, err := backend.Decrypt(encryptedSmallData, []byte("other/file")); err == nil {
+		t.Fatal("Small file was decrypted with mismatching associated data")
+	}
+
 	// Test large file encryption and decryption
 	largeData := make([]byte, 10*1024*1024) // 1 MB
 	if _, err := rand.Read(largeData); err != nil {
 		t.Fatalf("Failed to generate random data for large file: %v", err)
 	}
-	encryptedLargeData, err := backend.Encrypt(largeData)
+	encryptedLargeData, err := backend.Encrypt(largeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to encrypt large file: %v", err)
 	}
-	decryptedLargeData, err := backend.Decrypt(encryptedLargeData)
+	decryptedLargeData, err := backend.Decrypt(encryptedLargeData, associatedData)
 	if err != nil {
 		t.Fatalf("Failed to decrypt large file: %v", err)
 	}
@@ -64,3 +73,265 @@ func TestHPKEBackend(t *testing.T) {
 		t.Fatal("Large file encryption and decryption failed: data mismatch")
 	}
 }
+
+func TestHPKEBackendLegacyCiphertext(t *testing.T) {
+	suite, err := hpke.NewSuite(hpke.KemX25519HkdfSha256, hpke.KdfHkdfSha256, hpke.AeadChaCha20Poly1305)
+	if err != nil {
+		t.Fatalf("Failed to initialize HPKE suite: %v", err)
+	}
+	clientKp, err := suite.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate HPKE keypair: %v", err)
+	}
+	serverKp, err := suite.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate HPKE keypair: %v", err)
+	}
+
+	// Legacy objects were encrypted in auth-psk mode with an empty preshared key
+	clientCtx, enc, err := suite.NewAuthenticatedClientContext(clientKp, serverKp.PublicKey, []byte("go-safe"), &hpke.Psk{})
+	if err != nil {
+		t.Fatalf("Failed to create legacy client context: %v", err)
+	}
+	data := []byte("This is a legacy file.")
+	ciphertext, err := clientCtx.EncryptToServer(data, nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt legacy file: %v", err)
+	}
+	legacy, err := json.Marshal(struct {
+		EncryptedData []byte `json:"ed"`
+		SharedSecret  []byte `json:"ss"`
+	}{
+		EncryptedData: ciphertext,
+		SharedSecret:  enc,
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal legacy ciphertext: %v", err)
+	}
+
+	backend, err := NewHPKEBackend(clientKp.PublicKey, nil, nil, serverKp.SecretKey, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize encryption backend: %v", err)
+	}
+	decrypted, err := backend.Decrypt(legacy, nil)
+	if err != nil {
+		t.Fatalf("Failed to decrypt legacy file: %v", err)
+	}
+	if !bytes.Equal(data, decrypted) {
+		t.Fatal("Legacy file decryption failed: data mismatch")
+	}
+}
+
+func TestHPKEBackendSuites(t *testing.T) {
+	tests := []struct {
+		kem, kdf, aead string
+		mode           HPKEMode
+	}{
+		{"x25519", "sha256", "chacha20poly1305", HPKEModeBase},
+		{"p256", "sha256", "aes256gcm", HPKEModeBase},
+		{"p256", "sha256", "aes128gcm", HPKEModePSK},
+		{"p384", "sha384", "aes256gcm", HPKEModeAuth},
+		{"p521", "sha512", "aes256gcm", HPKEModeAuthPSK},
+		{"x448", "sha512", "chacha20poly1305", HPKEModeAuthPSK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kem+"/"+tt.kdf+"/"+tt.aead+"/"+string(tt.mode), func(t *testing.T) {
+			kemID, kdfID, aeadID, err := ParseHPKESuite(tt.kem, tt.kdf, tt.aead)
+			if err != nil {
+				t.Fatalf("Failed to parse HPKE suite: %v", err)
+			}
+			clientPublicKey, clientSecretKey, err := GenerateHPKEKeyPair(kemID)
+			if err != nil {
+				t.Fatalf("Failed to generate HPKE keypair: %v", err)
+			}
+			serverPublicKey, serverSecretKey, err := GenerateHPKEKeyPair(kemID)
+			if err != nil {
+				t.Fatalf("Failed to generate HPKE keypair: %v", err)
+			}
+
+			psk, pskID := []byte("0123456789abcdef0123456789abcdef"), []byte("psk")
+			if tt.mode == HPKEModeBase || tt.mode == HPKEModeAuth {
+				psk, pskID = nil, nil
+			}
+
+			// The daemon only holds what it needs to encrypt
+			encrypter := &HPKEBackend{}
+			err = encrypter.Initialize(&HPKEConfig{
+				UtilitySKey:    clientSecretKey,
+				YourPKey:       serverPublicKey,
+				PresharedKey:   psk,
+				PresharedKeyID: pskID,
+				KEM:            kemID,
+				KDF:            kdfID,
+				AEAD:           aeadID,
+				Mode:           tt.mode,
+			})
+			if err != nil {
+				t.Fatalf("Failed to initialize encryption backend: %v", err)
+			}
+
+			// The retriever picks the suite from the ciphertext, and only holds
+			// the client public key if ciphertexts are authenticated
+			if tt.mode == HPKEModeBase || tt.mode == HPKEModePSK {
+				clientPublicKey = nil
+			}
+			decrypter, err := NewHPKEBackend(clientPublicKey, nil, nil, serverSecretKey, psk, pskID)
+			if err != nil {
+				t.Fatalf("Failed to initialize decryption backend: %v", err)
+			}
+
+			associatedData := []byte("etc/passwd")
+			data := []byte("This is a small file.")
+			encrypted, err := encrypter.Encrypt(data, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to encrypt file: %v", err)
+			}
+			decrypted, err := decrypter.Decrypt(encrypted, associatedData)
+			if err != nil {
+				t.Fatalf("Failed to decrypt file: %v", err)
+			}
+			if !bytes.Equal(data, decrypted) {
+				t.Fatal("File encryption and decryption failed: data mismatch")
+			}
+		})
+	}
+}
+
+func TestHPKEBackendChunks(t *testing.T) {
+	serverPublicKey, serverSecretKey, err := GenerateHPKEKeyPair(0)
+	if err != nil {
+		t.Fatalf("Failed to generate HPKE keypair: %v", err)
+	}
+
+	backend, err := NewHPKEBackend(nil, nil, serverPublicKey, serverSecretKey, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to initialize encryption backend: %v", err)
+	}
+
+	// Sizes around the chunk boundaries
+	for _, size := range []int{0, 1, hpkeChunkSize - 1, hpkeChunkSize, hpkeChunkSize + 1, 3 * hpkeChunkSize} {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("Failed to generate random data: %v", err)
+		}
+
+		encrypted := new(bytes.Buffer)
+		if err := backend.encryptChunks(encrypted, bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("Failed to encrypt %d bytes: %v", size, err)
+		}
+		decrypted := new(bytes.Buffer)
+		if err := backend.decryptChunks(decrypted, bytes.NewReader(encrypted.Bytes()), nil); err != nil {
+			t.Fatalf("Failed to decrypt %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(data, decrypted.Bytes()) {
+			t.Fatalf("Chunked encryption and decryption of %d bytes failed: data mismatch", size)
+		}
+	}
+
+	associatedData := []byte("etc/passwd")
+	data := make([]byte, 3*hpkeChunkSize)
+	encrypted, err := backend.Encrypt(data, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to encrypt file: %v", err)
+	}
+
+	// The binary framing only adds a small constant overhead per chunk
+	if overhead := len(encrypted) - len(data); overhead > 256 {
+		t.Fatalf("Ciphertext overhead is too large: %d bytes", overhead)
+	}
+
+	// Dropping the final chunk must be detected
+	chunk := 5 + hpkeChunkSize + 16
+	if _, err := backend.Decrypt(encrypted[:len(encrypted)-chunk], associatedData); err == nil {
+		t.Fatal("Truncated ciphertext was decrypted")
+	}
+
+	// Tampering with the suite recorded in the header must be detected
+	tampered := append([]byte{}, encrypted...)
+	tampered[10] ^= 0x01 // ChaCha20-Poly1305 becomes AES-256-GCM
+	if _, err := backend.Decrypt(tampered, associatedData); err == nil {
+		t.Fatal("Ciphertext with a tampered header was decrypted")
+	}
+
+	// Chunk sizes other than the one always used must be rejected
+	tampered = append([]byte{}, encrypted...)
+	binary.BigEndian.PutUint32(tampered[12:16], hpkeChunkSize*2)
+	if _, err := backend.Decrypt(tampered, associatedData); err == nil {
+		t.Fatal("Ciphertext with another chunk size was decrypted")
+	}
+
+	// Flags other than final and not final must be rejected
+	tampered = append([]byte{}, encrypted...)
+	tampered[len(encrypted)-chunk] = 2
+	if _, err := backend.Decrypt(tampered, associatedData); err == nil {
+		t.Fatal("Ciphertext with a bad chunk flag was decrypted")
+	}
+
+	// Trailing data after the final chunk must be rejected
+	if _, err := backend.Decrypt(append(encrypted, 0), associatedData); err == nil {
+		t.Fatal("Ciphertext with trailing data was decrypted")
+	}
+}
+
+func TestHPKEBackendMinimumMode(t *testing.T) {
+	clientPublicKey, clientSecretKey, err := GenerateHPKEKeyPair(0)
+	if err != nil {
+		t.Fatalf("Failed to generate HPKE keypair: %v", err)
+	}
+	serverPublicKey, serverSecretKey, err := GenerateHPKEKeyPair(0)
+	if err != nil {
+		t.Fatalf("Failed to generate HPKE keypair: %v", err)
+	}
+	psk, pskID := []byte("0123456789abcdef0123456789abcdef"), []byte("psk")
+
+	tests := []struct {
+		name string
+		// Keys held by the retriever
+		clientPublicKey, psk, pskID []byte
+		// Modes it must accept, auth modes needing the client public key
+		accepted map[HPKEMode]bool
+	}{
+		{"server key", nil, nil, nil, map[HPKEMode]bool{HPKEModeBase: true}},
+		{"client key", clientPublicKey, nil, nil, map[HPKEMode]bool{HPKEModeAuth: true}},
+		{"preshared key", nil, psk, pskID, map[HPKEMode]bool{HPKEModePSK: true}},
+		{"both", clientPublicKey, psk, pskID, map[HPKEMode]bool{HPKEModeAuthPSK: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypter, err := NewHPKEBackend(tt.clientPublicKey, nil, nil, serverSecretKey, tt.psk, tt.pskID)
+			if err != nil {
+				t.Fatalf("Failed to initialize decryption backend: %v", err)
+			}
+
+			// Anyone knowing the server public key can encrypt in base or psk
+			// mode, only the client in auth modes
+			for mode := range hpkeModeIDs {
+				encrypter := &HPKEBackend{}
+				err := encrypter.Initialize(&HPKEConfig{
+					UtilitySKey:    clientSecretKey,
+					YourPKey:       serverPublicKey,
+					PresharedKey:   psk,
+					PresharedKeyID: pskID,
+					Mode:           mode,
+				})
+				if err != nil {
+					t.Fatalf("Failed to initialize encryption backend: %v", err)
+				}
+				encrypted, err := encrypter.Encrypt([]byte("data"), nil)
+				if err != nil {
+					t.Fatalf("Failed to encrypt in %s mode: %v", mode, err)
+				}
+
+				_, err = decrypter.Decrypt(encrypted, nil)
+				if tt.accepted[mode] && err != nil {
+					t.Errorf("Expected %s mode to be accepted, got %v", mode, err)
+				}
+				if !tt.accepted[mode] && err == nil {
+					t.Errorf("Expected %s mode to be refused", mode)
+				}
+			}
+		})
+	}
+}
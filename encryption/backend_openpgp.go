@@ -0,0 +1,158 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// OpenPGPEncryptionConfig represents the configuration for an OpenPGP encryption backend.
+type OpenPGPEncryptionConfig struct {
+	// PublicKeys are the armored public keys to encrypt to.
+	PublicKeys []string
+	// PrivateKey is the armored secret key used to decrypt.
+	PrivateKey string
+	// Passphrase unlocks the secret key if it is protected.
+	Passphrase []byte
+}
+
+// OpenPGPEncryptionBackend represents an encryption backend producing OpenPGP messages.
+type OpenPGPEncryptionBackend struct {
+	recipients openpgp.EntityList
+	keyring    openpgp.EntityList
+	config     *packet.Config
+}
+
+// NewOpenPGPEncryptionBackend creates a new OpenPGP encryption backend.
+func NewOpenPGPEncryptionBackend(publicKeys []string, privateKey string, passphrase []byte) (*OpenPGPEncryptionBackend, error) {
+	b := OpenPGPEncryptionBackend{}
+	err := b.Initialize(&OpenPGPEncryptionConfig{
+		PublicKeys: publicKeys,
+		PrivateKey: privateKey,
+		Passphrase: passphrase,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Initialize initializes the OpenPGP encryption backend with the public and private keys.
+func (e *OpenPGPEncryptionBackend) Initialize(cfg EncryptionConfig) error {
+	// Check the configuration type
+	config, ok := cfg.(*OpenPGPEncryptionConfig)
+	if !ok {
+		return errors.New("invalid OpenPGP encryption configuration")
+	}
+
+	// Stick to algorithms understood by every gpg release
+	e.config = &packet.Config{
+		DefaultHash:            crypto.SHA256,
+		DefaultCipher:          packet.CipherAES256,
+		DefaultCompressionAlgo: packet.CompressionNone,
+	}
+
+	e.recipients = nil
+	for _, publicKey := range config.PublicKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(publicKey))
+		if err != nil {
+			return fmt.Errorf("failed to read OpenPGP public key: %v", err)
+		}
+		e.recipients = append(e.recipients, entities...)
+	}
+
+	e.keyring = nil
+	if strings.TrimSpace(config.PrivateKey) != "" {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(config.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("failed to read OpenPGP private key: %v", err)
+		}
+
+		// Unlock the secret keys once, rather than on every message
+		for _, entity := range entities {
+			if entity.PrivateKey == nil {
+				return errors.New("OpenPGP private key does not contain a secret key")
+			}
+			if err := entity.DecryptPrivateKeys(config.Passphrase); err != nil {
+				return fmt.Errorf("failed to unlock OpenPGP private key: %v", err)
+			}
+		}
+		e.keyring = entities
+	}
+
+	return nil
+}
+
+// Encrypt encrypts the data to every configured public key.
+//
+// OpenPGP has no associated data, so its digest is stored as the file name of
+// the literal data packet, which is integrity protected along with the data.
+// gpg ignores it unless asked to use the embedded file name.
+func (e *OpenPGPEncryptionBackend) Encrypt(data []byte, associatedData []byte) ([]byte, error) {
+	if len(e.recipients) == 0 {
+		return nil, errors.New("no OpenPGP public keys configured")
+	}
+
+	out := new(bytes.Buffer)
+	hints := &openpgp.FileHints{
+		IsBinary: true,
+		FileName: openPGPAssociatedDataName(associatedData),
+	}
+	w, err := openpgp.Encrypt(out, e.recipients, nil, hints, e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	// Closing the writer appends the integrity protection packet
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts the data using the configured private key.
+func (e *OpenPGPEncryptionBackend) Decrypt(data []byte, associatedData []byte) ([]byte, error) {
+	if len(e.keyring) == 0 {
+		return nil, errors.New("no OpenPGP private key configured")
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(data), e.keyring, nil, e.config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The integrity check happens once the whole body has been read
+	decryptedData, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if md.LiteralData == nil || md.LiteralData.FileName != openPGPAssociatedDataName(associatedData) {
+		return nil, errors.New("OpenPGP associated data mismatch")
+	}
+
+	return decryptedData, nil
+}
+
+// openPGPAssociatedDataName returns the literal data file name binding the associated data.
+func openPGPAssociatedDataName(associatedData []byte) string {
+	if associatedData == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(associatedData)
+	return "go-safe-" + hex.EncodeToString(sum[:])
+}
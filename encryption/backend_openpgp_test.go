@@ -0,0 +1,105 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func generateOpenPGPKey(t *testing.T, name string, passphrase []byte) (string, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate OpenPGP key: %v", err)
+	}
+
+	public := new(strings.Builder)
+	w, err := armor.Encode(public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to armor OpenPGP public key: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("Failed to serialize OpenPGP public key: %v", err)
+	}
+	w.Close()
+
+	if err := entity.EncryptPrivateKeys(passphrase, nil); err != nil {
+		t.Fatalf("Failed to protect OpenPGP private key: %v", err)
+	}
+
+	private := new(strings.Builder)
+	w, err = armor.Encode(private, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to armor OpenPGP private key: %v", err)
+	}
+	if err := entity.SerializePrivateWithoutSigning(w, nil); err != nil {
+		t.Fatalf("Failed to serialize OpenPGP private key: %v", err)
+	}
+	w.Close()
+
+	return public.String(), private.String()
+}
+
+func TestOpenPGPEncryptionBackend(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	corporatePublic, corporatePrivate := generateOpenPGPKey(t, "corporate", passphrase)
+	escrowPublic, _ := generateOpenPGPKey(t, "escrow", passphrase)
+
+	// Initialize the encryption backend
+	backend, err := NewOpenPGPEncryptionBackend([]string{corporatePublic, escrowPublic}, corporatePrivate, passphrase)
+	if err != nil {
+		t.Fatalf("Failed to initialize encryption backend: %v", err)
+	}
+
+	// A wrong passphrase must not unlock the private key
+	if _, err := NewOpenPGPEncryptionBackend(nil, corporatePrivate, []byte("wrong")); err == nil {
+		t.Fatal("Private key was unlocked with a wrong passphrase")
+	}
+
+	associatedData := []byte("etc/passwd")
+
+	// Test small file encryption and decryption
+	smallData := []byte("This is a small file.")
+	encryptedSmallData, err := backend.Encrypt(smallData, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to encrypt small file: %v", err)
+	}
+	decryptedSmallData, err := backend.Decrypt(encryptedSmallData, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to decrypt small file: %v", err)
+	}
+	if !bytes.Equal(smallData, decryptedSmallData) {
+		t.Fatal("Small file encryption and decryption failed: data mismatch")
+	}
+
+	// Decryption must fail with different associated data
+	if _, err := backend.Decrypt(encryptedSmallData, []byte("other/file")); err == nil {
+		t.Fatal("Small file was decrypted with mismatching associated data")
+	}
+
+	// Test large file encryption and decryption
+	largeData := make([]byte, 10*1024*1024) // 10 MB
+	if _, err := rand.Read(largeData); err != nil {
+		t.Fatalf("Failed to generate random data for large file: %v", err)
+	}
+	encryptedLargeData, err := backend.Encrypt(largeData, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to encrypt large file: %v", err)
+	}
+	decryptedLargeData, err := backend.Decrypt(encryptedLargeData, associatedData)
+	if err != nil {
+		t.Fatalf("Failed to decrypt large file: %v", err)
+	}
+	if !bytes.Equal(largeData, decryptedLargeData) {
+		t.Fatal("Large file encryption and decryption failed: data mismatch")
+	}
+
+	// Tampered ciphertexts must be rejected
+	encryptedSmallData[len(encryptedSmallData)-1] ^= 0xff
+	if _, err := backend.Decrypt(encryptedSmallData, associatedData); err == nil {
+		t.Fatal("Tampered ciphertext was decrypted")
+	}
+}
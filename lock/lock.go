@@ -0,0 +1,261 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yyewolf/go-safe/repository"
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// Prefix is the prefix of the keys of the locks, under the reserved prefix of
+// the repository so that no backed up file can be mistaken for a lock.
+const Prefix = repository.Prefix + "locks/"
+
+// DefaultTTL is how long a lock stays valid after its last heartbeat.
+const DefaultTTL = 5 * time.Minute
+
+// ErrLocked is returned when the repository is locked by someone else.
+var ErrLocked = errors.New("repository is locked")
+
+// Lock is held on a repository while writing to it. Locks are plain JSON
+// stored through the storage backend, so that every party can read them,
+// even those which can only encrypt. Each lock has its own key, so taking a
+// lock never overwrites another one. A lock which can't be deleted (e.g. with
+// credentials which can't delete, in append-only mode) is released by
+// storing it again, expired.
+type Lock struct {
+	ID string `json:"id"`
+	// Owner identifies the locker which took the lock, Hostname and PID the
+	// process it runs in
+	Owner    string `json:"owner"`
+	Hostname string `json:"hostname"`
+	PID      int    `json:"pid"`
+	// Exclusive locks can't be held along any other lock, e.g. when writing
+	Exclusive bool      `json:"exclusive"`
+	Created   time.Time `json:"created"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Key returns the key the lock is stored at.
+func (l *Lock) Key() string {
+	return Prefix + l.ID
+}
+
+// Expired returns whether the lock is stale.
+func (l *Lock) Expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// conflicts returns whether the lock can't be held along the other one.
+func (l *Lock) conflicts(other *Lock) bool {
+	return l.Exclusive || other.Exclusive
+}
+
+// Locker takes and releases locks on the repository of a storage backend.
+type Locker struct {
+	backend storage.StorageBackend
+	owner   string
+	ttl     time.Duration
+}
+
+// NewLocker creates a new locker for the repository of the storage backend,
+// with a random owner ID. Its locks expire when no heartbeat was recorded
+// for the TTL.
+func NewLocker(backend storage.StorageBackend, ttl time.Duration) (*Locker, error) {
+	if backend == nil {
+		return nil, errors.New("backend cannot be nil")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+
+	owner, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Locker{
+		backend: backend,
+		owner:   owner,
+		ttl:     ttl,
+	}, nil
+}
+
+// Owner returns the owner ID recorded in the locks.
+func (l *Locker) Owner() string {
+	return l.owner
+}
+
+// Lock takes a lock, failing with ErrLocked if a conflicting lock is held.
+// Storage backends can't create an object only if it doesn't exist, so the
+// locks are listed again once stored, and if two parties raced, both back off.
+func (l *Locker) Lock(exclusive bool) (*Lock, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+
+	now := time.Now().UTC()
+	lock := &Lock{
+		ID:        id,
+		Owner:     l.owner,
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		Exclusive: exclusive,
+		Created:   now,
+		Heartbeat: now,
+		Expires:   now.Add(l.ttl),
+	}
+
+	if err := l.check(lock); err != nil {
+		return nil, err
+	}
+
+	if err := l.store(lock); err != nil {
+		return nil, err
+	}
+
+	if err := l.check(lock); err != nil {
+		l.Unlock(lock)
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// check returns ErrLocked if a lock conflicting with the lock is held.
+// Expired locks are ignored, among which those released without being
+// deleted, whose expiry is the time they were released at.
+func (l *Locker) check(lock *Lock) error {
+	locks, err := List(l.backend)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, other := range locks {
+		if other.ID == lock.ID || other.Expired(now) {
+			continue
+		}
+		if lock.conflicts(other) {
+			return fmt.Errorf("%w by %s (owner %s, pid %d) since %s", ErrLocked, other.Hostname, other.Owner, other.PID, other.Created.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// store stores the lock under its key.
+func (l *Locker) store(lock *Lock) error {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	return l.backend.Store(lock.Key(), 0, data)
+}
+
+// Refresh records a heartbeat, extending the lock for another TTL.
+func (l *Locker) Refresh(lock *Lock) error {
+	refreshed := *lock
+	refreshed.Heartbeat = time.Now().UTC()
+	refreshed.Expires = refreshed.Heartbeat.Add(l.ttl)
+
+	return l.store(&refreshed)
+}
+
+// KeepAlive refreshes the lock in the background, three times per TTL, until
+// the returned function is called. Failed heartbeats are passed to onError,
+// and retried at the next one.
+func (l *Locker) KeepAlive(lock *Lock, onError func(error)) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := l.Refresh(lock); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Unlock releases a lock. If it can't be deleted, it is stored again expired
+// as of now, so that it doesn't block anyone until the end of its TTL.
+func (l *Locker) Unlock(lock *Lock) error {
+	err := l.backend.Delete(lock.Key())
+	if err == nil {
+		return nil
+	}
+
+	released := *lock
+	released.Expires = time.Now().UTC()
+	if err := l.store(&released); err != nil {
+		return fmt.Errorf("failed to release lock: %v", err)
+	}
+
+	return nil
+}
+
+// List returns the locks stored in the repository, stale ones included.
+func List(backend storage.StorageBackend) ([]*Lock, error) {
+	objects, err := backend.List(Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []*Lock
+	for _, object := range objects {
+		data, err := backend.Retrieve(object.Key, 0)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Released in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lock := &Lock{}
+		if err := json.Unmarshal(data, lock); err != nil {
+			return nil, fmt.Errorf("invalid lock %s: %v", object.Key, err)
+		}
+		if lock.Key() != object.Key {
+			return nil, fmt.Errorf("invalid lock %s: stored under the wrong key", object.Key)
+		}
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+// randomID returns a random hexadecimal ID.
+func randomID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
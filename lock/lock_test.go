@@ -0,0 +1,171 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+// noDeleteBackend refuses deletions, like S3 credentials which can't delete.
+type noDeleteBackend struct {
+	*storagetest.MemoryBackend
+}
+
+func (b noDeleteBackend) Delete(key string) error {
+	return errors.New("access denied")
+}
+
+func TestLocker(t *testing.T) {
+	backend := storagetest.NewMemoryBackend()
+
+	locker, err := NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+
+	// Shared locks can be held together
+	first, err := locker.Lock(false)
+	if err != nil {
+		t.Fatalf("Failed to take shared lock: %v", err)
+	}
+	second, err := locker.Lock(false)
+	if err != nil {
+		t.Fatalf("Failed to take second shared lock: %v", err)
+	}
+
+	if _, err := locker.Lock(true); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for an exclusive lock, got %v", err)
+	}
+	if backend.Len() != 2 {
+		t.Errorf("Expected the refused lock to be cleaned up, got %d locks", backend.Len())
+	}
+
+	locker.Unlock(first)
+	locker.Unlock(second)
+
+	exclusive, err := locker.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed to take exclusive lock: %v", err)
+	}
+	if _, err := locker.Lock(false); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for a shared lock, got %v", err)
+	}
+	locker.Unlock(exclusive)
+
+	// A backed up file under locks/ isn't a lock
+	backend.Put("locks/notes.txt", []byte("not a lock"))
+
+	locks, err := List(backend)
+	if err != nil {
+		t.Fatalf("Failed to list locks: %v", err)
+	}
+	if len(locks) != 0 {
+		t.Errorf("Expected no lock, got %d", len(locks))
+	}
+}
+
+func TestLockerExpired(t *testing.T) {
+	backend := storagetest.NewMemoryBackend()
+
+	stale, err := NewLocker(backend, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+	if _, err := stale.Lock(true); err != nil {
+		t.Fatalf("Failed to take exclusive lock: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// A lock which was never released doesn't block forever
+	locker, err := NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+	if _, err := locker.Lock(true); err != nil {
+		t.Errorf("Expected the stale lock to be ignored, got %v", err)
+	}
+}
+
+func TestLockerHeartbeat(t *testing.T) {
+	backend := storagetest.NewMemoryBackend()
+
+	locker, err := NewLocker(backend, 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+
+	lock, err := locker.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed to take exclusive lock: %v", err)
+	}
+	if lock.Owner != locker.Owner() || lock.Hostname == "" || lock.PID == 0 {
+		t.Errorf("Lock does not identify its owner: %+v", lock)
+	}
+
+	// Heartbeats keep the lock alive past its TTL
+	stop := locker.KeepAlive(lock, func(err error) {
+		t.Errorf("Failed to refresh lock: %v", err)
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	other, err := NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+	if _, err := other.Lock(true); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while the lock is kept alive, got %v", err)
+	}
+
+	// Once heartbeats stop, the lock expires
+	stop()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := other.Lock(true); err != nil {
+		t.Errorf("Expected the lock to expire without heartbeats, got %v", err)
+	}
+}
+
+func TestLockerNoDelete(t *testing.T) {
+	backend := noDeleteBackend{storagetest.NewMemoryBackend()}
+
+	locker, err := NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+	other, err := NewLocker(backend, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to initialize locker: %v", err)
+	}
+
+	// Every cycle takes and releases its lock
+	for i := 0; i < 3; i++ {
+		held, err := locker.Lock(true)
+		if err != nil {
+			t.Fatalf("Failed to take exclusive lock after %d cycles: %v", i, err)
+		}
+		if err := locker.Unlock(held); err != nil {
+			t.Fatalf("Failed to release lock: %v", err)
+		}
+	}
+
+	// Released locks don't block others either, e.g. prune
+	held, err := other.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed to take exclusive lock after the releases: %v", err)
+	}
+
+	// The released locks are left behind, expired, for prune to clean up
+	locks, err := List(backend)
+	if err != nil {
+		t.Fatalf("Failed to list locks: %v", err)
+	}
+	if len(locks) != 4 {
+		t.Fatalf("Expected 4 locks, got %d", len(locks))
+	}
+	for _, l := range locks {
+		if l.ID != held.ID && !l.Expired(time.Now()) {
+			t.Errorf("Expected the released lock %s to be expired", l.ID)
+		}
+	}
+}
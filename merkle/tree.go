@@ -0,0 +1,164 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Domain separation between files and directories, so that a file can't be
+// passed off as a directory or the other way around.
+const (
+	fileTag      = 0x00
+	directoryTag = 0x01
+)
+
+// Node represents a file or a directory of a snapshot.
+type Node struct {
+	// Hash is the hash of the node, covering everything below it.
+	Hash string `json:"h"`
+	// Sum is the SHA256 sum of a file, empty for directories.
+	Sum string `json:"s,omitempty"`
+	// Children are the entries of a directory, by name.
+	Children map[string]*Node `json:"c,omitempty"`
+}
+
+// Build builds the tree of a snapshot from the SHA256 sums of its files, by path.
+func Build(files map[string]string) (*Node, error) {
+	root := &Node{Children: make(map[string]*Node)}
+
+	for p, sum := range files {
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid sum for %s", p)
+		}
+
+		parts := split(p)
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+
+		dir := root
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := dir.Children[part]
+			if !ok {
+				child = &Node{Children: make(map[string]*Node)}
+				dir.Children[part] = child
+			}
+			if child.Children == nil {
+				return nil, fmt.Errorf("%s is both a file and a directory", part)
+			}
+			dir = child
+		}
+
+		name := parts[len(parts)-1]
+		if _, ok := dir.Children[name]; ok {
+			return nil, fmt.Errorf("%s is both a file and a directory", p)
+		}
+		dir.Children[name] = &Node{Sum: sum}
+	}
+
+	root.Hash = root.compute()
+	return root, nil
+}
+
+// compute computes the hashes of the node and everything below it.
+func (n *Node) compute() string {
+	h := sha256.New()
+
+	if n.Children == nil {
+		sum, _ := hex.DecodeString(n.Sum)
+		h.Write([]byte{fileTag})
+		h.Write(sum)
+	} else {
+		h.Write([]byte{directoryTag})
+		for _, name := range n.names() {
+			child := n.Children[name]
+			child.Hash = child.compute()
+			hash, _ := hex.DecodeString(child.Hash)
+
+			binary.Write(h, binary.BigEndian, uint32(len(name)))
+			h.Write([]byte(name))
+			h.Write(hash)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify recomputes every hash of the tree and checks them against the
+// recorded ones and the expected root hash.
+func (n *Node) Verify(root string) error {
+	// Rebuild the tree from its files alone, the recorded hashes must match
+	tree, err := Build(n.Files())
+	if err != nil {
+		return err
+	}
+	if tree.Hash != root {
+		return errors.New("root hash mismatch")
+	}
+
+	recorded := n.hashes()
+	computed := tree.hashes()
+	if len(recorded) != len(computed) {
+		return errors.New("tree structure mismatch")
+	}
+	for p, hash := range computed {
+		if recorded[p] != hash {
+			return fmt.Errorf("hash mismatch for /%s", p)
+		}
+	}
+
+	return nil
+}
+
+// Files returns the SHA256 sums of the files of the tree, by path.
+func (n *Node) Files() map[string]string {
+	files := make(map[string]string)
+	n.walk("", func(p string, node *Node) {
+		if p != "" && node.Children == nil {
+			files[p] = node.Sum
+		}
+	})
+	return files
+}
+
+// hashes returns the hashes of every node of the tree, by path.
+func (n *Node) hashes() map[string]string {
+	hashes := make(map[string]string)
+	n.walk("", func(p string, node *Node) {
+		hashes[p] = node.Hash
+	})
+	return hashes
+}
+
+func (n *Node) walk(p string, fn func(string, *Node)) {
+	fn(p, n)
+	for _, name := range n.names() {
+		n.Children[name].walk(path.Join(p, name), fn)
+	}
+}
+
+func (n *Node) names() []string {
+	names := make([]string, 0, len(n.Children))
+	for name := range n.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// split splits a path in its non-empty parts, whatever its separators.
+func split(p string) []string {
+	var parts []string
+	for _, part := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,109 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func sum(data string) string {
+	s := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(s[:])
+}
+
+func TestTree(t *testing.T) {
+	files := map[string]string{
+		"etc/passwd":                     sum("root:x:0:0"),
+		"etc/hosts":                      sum("127.0.0.1 localhost"),
+		"home/user/.ssh/authorized_keys": sum("ssh-ed25519 AAAA"),
+		"README":                         sum("hello"),
+	}
+
+	tree, err := Build(files)
+	if err != nil {
+		t.Fatalf("Failed to build tree: %v", err)
+	}
+	root := tree.Hash
+
+	// The manifest round-trips through JSON and verifies against the root hash
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("Failed to marshal tree: %v", err)
+	}
+	var manifest Node
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Failed to unmarshal tree: %v", err)
+	}
+	if err := manifest.Verify(root); err != nil {
+		t.Fatalf("Failed to verify tree: %v", err)
+	}
+	if len(manifest.Files()) != len(files) {
+		t.Fatal("Tree files mismatch")
+	}
+
+	// The root hash doesn't depend on the order of insertion
+	again, err := Build(manifest.Files())
+	if err != nil || again.Hash != root {
+		t.Fatal("Tree root hash is not deterministic")
+	}
+
+	// Any change of content, name or structure changes the root hash
+	changes := []map[string]string{
+		{"etc/passwd": sum("root:x:0:1")},
+		{"etc/passwd2": files["etc/passwd"]},
+		{"etc/group": sum("root:x:0:")},
+	}
+	for _, change := range changes {
+		modified := make(map[string]string)
+		for p, s := range files {
+			modified[p] = s
+		}
+		delete(modified, "etc/passwd")
+		for p, s := range change {
+			modified[p] = s
+		}
+		other, err := Build(modified)
+		if err != nil {
+			t.Fatalf("Failed to build tree: %v", err)
+		}
+		if other.Hash == root {
+			t.Fatalf("Root hash did not change with %v", change)
+		}
+	}
+
+	// A tampered manifest must be rejected
+	manifest.Children["etc"].Children["passwd"].Sum = sum("root::0:0")
+	if err := manifest.Verify(root); err == nil {
+		t.Fatal("Tampered file sum was verified")
+	}
+	json.Unmarshal(data, &manifest)
+	manifest.Children["etc"].Hash = root
+	if err := manifest.Verify(root); err == nil {
+		t.Fatal("Tampered directory hash was verified")
+	}
+	json.Unmarshal(data, &manifest)
+	delete(manifest.Children["etc"].Children, "hosts")
+	if err := manifest.Verify(root); err == nil {
+		t.Fatal("Incomplete manifest was verified")
+	}
+
+	// Conflicting paths can't be represented
+	if _, err := Build(map[string]string{"etc": sum("a"), "etc/passwd": sum("b")}); err == nil {
+		t.Fatal("Path used as both a file and a directory was accepted")
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	tree, err := Build(map[string]string{})
+	if err != nil {
+		t.Fatalf("Failed to build empty tree: %v", err)
+	}
+
+	data, _ := json.Marshal(tree)
+	var manifest Node
+	json.Unmarshal(data, &manifest)
+	if err := manifest.Verify(tree.Hash); err != nil {
+		t.Fatalf("Failed to verify empty tree: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package naming
+
+import "encoding/hex"
+
+// Namer represents a way to map the path of a file to the key of its object
+// in the storage backend.
+type Namer interface {
+	// Name returns the key of the object storing the file at path.
+	Name(path string) (string, error)
+}
+
+// opaqueKey returns a key made of the hex encoded identifier, under a
+// directory named after its first byte so that listings stay small.
+func opaqueKey(id []byte) string {
+	h := hex.EncodeToString(id)
+	return h[:2] + "/" + h
+}
@@ -0,0 +1,31 @@
+package naming
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// HMACNamer represents a namer using a keyed HMAC of the path as key. A file
+// always gets the same key, so names stay stable across index losses.
+type HMACNamer struct {
+	key []byte
+}
+
+// NewHMACNamer creates a new HMAC namer. The key must be at least 32 bytes long.
+func NewHMACNamer(key []byte) (*HMACNamer, error) {
+	if len(key) < 32 {
+		return nil, errors.New("HMAC naming key must be at least 32 bytes long")
+	}
+
+	return &HMACNamer{
+		key: key,
+	}, nil
+}
+
+// Name returns the HMAC-SHA256 of the path.
+func (n *HMACNamer) Name(path string) (string, error) {
+	mac := hmac.New(sha256.New, n.key)
+	mac.Write([]byte(path))
+	return opaqueKey(mac.Sum(nil)), nil
+}
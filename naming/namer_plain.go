@@ -0,0 +1,15 @@
+package naming
+
+// PlainNamer represents a namer using paths as keys, which exposes the
+// directory structure and file names to anyone listing the bucket.
+type PlainNamer struct{}
+
+// NewPlainNamer creates a new plain namer.
+func NewPlainNamer() *PlainNamer {
+	return &PlainNamer{}
+}
+
+// Name returns the path itself.
+func (n *PlainNamer) Name(path string) (string, error) {
+	return path, nil
+}
@@ -0,0 +1,21 @@
+package naming
+
+import "crypto/rand"
+
+// RandomNamer represents a namer using random identifiers as keys, which
+// reveal nothing about the files even to holders of the keys.
+type RandomNamer struct{}
+
+// NewRandomNamer creates a new random namer.
+func NewRandomNamer() *RandomNamer {
+	return &RandomNamer{}
+}
+
+// Name returns a new random 128 bits identifier on every call.
+func (n *RandomNamer) Name(path string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return opaqueKey(id), nil
+}
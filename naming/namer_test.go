@@ -0,0 +1,67 @@
+package naming
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+func TestNamers(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate random key: %v", err)
+	}
+
+	hmacNamer, err := NewHMACNamer(key)
+	if err != nil {
+		t.Fatalf("Failed to initialize HMAC namer: %v", err)
+	}
+	if _, err := NewHMACNamer(key[:16]); err == nil {
+		t.Fatal("Short HMAC key was accepted")
+	}
+
+	path := "home/user/.ssh/authorized_keys"
+
+	// Plain names are the paths
+	name, err := NewPlainNamer().Name(path)
+	if err != nil || name != path {
+		t.Fatalf("Unexpected plain name %q: %v", name, err)
+	}
+
+	tests := []struct {
+		name   string
+		namer  Namer
+		stable bool
+	}{
+		{"HMAC", hmacNamer, true},
+		{"Random", NewRandomNamer(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := tt.namer.Name(path)
+			if err != nil {
+				t.Fatalf("Failed to name path: %v", err)
+			}
+			second, err := tt.namer.Name(path)
+			if err != nil {
+				t.Fatalf("Failed to name path: %v", err)
+			}
+			other, err := tt.namer.Name("etc/passwd")
+			if err != nil {
+				t.Fatalf("Failed to name path: %v", err)
+			}
+
+			// Names must not leak anything about the path
+			if strings.Contains(first, "ssh") || strings.Count(first, "/") != 1 || !strings.HasPrefix(first[3:], first[:2]) {
+				t.Fatalf("Unexpected opaque name %q", first)
+			}
+			if (first == second) != tt.stable {
+				t.Fatalf("Names of the same path: %q and %q", first, second)
+			}
+			if first == other {
+				t.Fatal("Different paths got the same name")
+			}
+		})
+	}
+}
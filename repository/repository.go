@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// Prefix is the reserved prefix of the objects of the repository itself, in
+// every namespace. Files under it are never backed up, so that no file key
+// can collide with them.
+const Prefix = ".gosafe/"
+
+// Keys and prefixes of the objects of the repository.
+const (
+	// ConfigKey is the key of the repository config, at the root of the
+	// repository
+	ConfigKey = Prefix + "config.gosafe"
+	// HeadKey is the key of the pointer to the latest generation of the index
+	HeadKey = Prefix + "head.gosafe"
+	// IndexPrefix and ManifestPrefix are the prefixes of the generations of
+	// the index and of their manifests
+	IndexPrefix    = Prefix + "index/"
+	ManifestPrefix = Prefix + "manifest/"
+)
+
+// HostsPrefix is the prefix of the namespaces of the hosts.
+const HostsPrefix = "hosts/"
+
+// FormatVersion is the version of the repository format written by this
+// version of go-safe.
+const FormatVersion = 1
+
+// ErrMismatch is returned when the repository was created with another ID or
+// encryption backend than the configured one.
+var ErrMismatch = errors.New("repository config mismatch")
+
+// Encryption records how the objects of the repository are encrypted.
+type Encryption struct {
+	Backend string `json:"backend"`
+	// Parameters of the backend which are not secret, e.g. the HPKE suite
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// Config describes a repository. It is plain JSON stored at the root of the
+// repository, so that every party can read it, even those which can only
+// encrypt. It holds nothing secret, and tampering with it can't make objects
+// readable: the repository ID is bound to every object.
+type Config struct {
+	Version    int        `json:"version"`
+	ID         string     `json:"id"`
+	Encryption Encryption `json:"encryption"`
+	// Namespaces is whether every host stores its files and its index under
+	// its own namespace. Repositories created before namespaces store a
+	// single host at their root.
+	Namespaces bool      `json:"namespaces"`
+	Created    time.Time `json:"created"`
+}
+
+// NewConfig creates the config of a new repository with host namespaces. A
+// random repository ID is generated if id is empty.
+func NewConfig(id string, encryption Encryption) (*Config, error) {
+	if encryption.Backend == "" {
+		return nil, errors.New("encryption backend cannot be empty")
+	}
+
+	if id == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		id = hex.EncodeToString(random)
+	}
+
+	return &Config{
+		Version:    FormatVersion,
+		ID:         id,
+		Encryption: encryption,
+		Namespaces: true,
+		Created:    time.Now().UTC(),
+	}, nil
+}
+
+// Check returns ErrMismatch if the repository doesn't have the given ID or
+// encryption backend. Empty values aren't checked.
+func (c *Config) Check(id string, encryptionBackend string) error {
+	if id != "" && id != c.ID {
+		return fmt.Errorf("%w: repository ID is %q, not %q", ErrMismatch, c.ID, id)
+	}
+	if encryptionBackend != "" && encryptionBackend != c.Encryption.Backend {
+		return fmt.Errorf("%w: repository is encrypted with %s, not %s", ErrMismatch, c.Encryption.Backend, encryptionBackend)
+	}
+	return nil
+}
+
+// Namespace returns the prefix under which the host stores its objects, or
+// an empty string if the repository has no namespaces.
+func (c *Config) Namespace(host string) string {
+	if !c.Namespaces {
+		return ""
+	}
+	return HostsPrefix + host + "/"
+}
+
+// BoundID returns the ID to bind to the objects of the host. In repositories
+// with namespaces, it includes the host, so that the objects of a host can't
+// be passed off as those of another one.
+func (c *Config) BoundID(host string) string {
+	if !c.Namespaces {
+		return c.ID
+	}
+	return c.ID + "/" + HostsPrefix + host
+}
+
+// Load loads the config of the repository, returning storage.ErrNotFound if
+// there is none.
+func Load(backend storage.StorageBackend) (*Config, error) {
+	data, err := backend.Retrieve(ConfigKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid repository config: %v", err)
+	}
+	if config.Version > FormatVersion {
+		return nil, fmt.Errorf("repository format version %d is newer than the supported version %d", config.Version, FormatVersion)
+	}
+
+	return config, nil
+}
+
+// Save stores the config of the repository.
+func Save(backend storage.StorageBackend, config *Config) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return backend.Store(ConfigKey, 0, data)
+}
+
+// Legacy returns whether the repository holds an index from before the
+// repository config, which must keep being stored at its root.
+func Legacy(backend storage.StorageBackend) (bool, error) {
+	for _, prefix := range []string{"db.gosafe", IndexPrefix} {
+		objects, err := backend.List(prefix)
+		if err != nil {
+			return false, err
+		}
+		if len(objects) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ManifestKey returns the key of the manifest of a generation of the index.
+func ManifestKey(indexKey string) string {
+	return ManifestPrefix + strings.TrimPrefix(indexKey, IndexPrefix)
+}
+
+// Reserved returns whether the file at the slash separated path can't be
+// backed up, its key colliding with the objects of the repository: anything
+// under the reserved prefix, and db.gosafe and manifest.gosafe at the root,
+// where the index was stored before generations.
+func Reserved(path string) bool {
+	return path == strings.TrimSuffix(Prefix, "/") || strings.HasPrefix(path, Prefix) ||
+		path == "db.gosafe" || path == "manifest.gosafe"
+}
+
+// TempPattern is the pattern of the names of the temporary files written next
+// to the files being restored, before being renamed over them.
+const TempPattern = ".gosafe-*"
+
+// Temporary returns whether the file at the slash separated path is a
+// temporary file of a restore, which is never backed up.
+func Temporary(p string) bool {
+	ok, _ := path.Match(TempPattern, path.Base(p))
+	return ok
+}
+
+// ValidateHost returns an error if the host can't be used as a namespace.
+func ValidateHost(host string) error {
+	if host == "" {
+		return errors.New("host cannot be empty")
+	}
+	if host == "." || host == ".." || strings.ContainsAny(host, "/\\") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// Host summarizes the namespace of a host.
+type Host struct {
+	Name    string
+	Objects int
+	Size    int64
+	// LastBackup is when the index of the host was last uploaded
+	LastBackup time.Time
+}
+
+// Hosts returns the hosts which stored objects in the repository, sorted by
+// name.
+func Hosts(backend storage.StorageBackend) ([]*Host, error) {
+	objects, err := backend.List(HostsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]*Host)
+	for _, object := range objects {
+		name, key, ok := strings.Cut(strings.TrimPrefix(object.Key, HostsPrefix), "/")
+		if !ok {
+			continue
+		}
+
+		host, ok := hosts[name]
+		if !ok {
+			host = &Host{Name: name}
+			hosts[name] = host
+		}
+		host.Objects++
+		host.Size += object.Size
+
+		if strings.HasPrefix(key, IndexPrefix) && object.LastModified.After(host.LastBackup) {
+			host.LastBackup = object.LastModified
+		}
+	}
+
+	list := make([]*Host, 0, len(hosts))
+	for _, host := range hosts {
+		list = append(list, host)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
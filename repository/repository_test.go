@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+func TestConfig(t *testing.T) {
+	backend := storagetest.NewMemoryBackend()
+
+	if _, err := Load(backend); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound without a config, got %v", err)
+	}
+
+	config, err := NewConfig("", Encryption{Backend: "hpke", Parameters: map[string]string{"kem": "x25519"}})
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	if config.ID == "" {
+		t.Error("Expected a random repository ID")
+	}
+	if err := Save(backend, config); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+
+	loaded, err := Load(backend)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if loaded.ID != config.ID || !loaded.Namespaces || loaded.Encryption.Parameters["kem"] != "x25519" {
+		t.Errorf("Loaded config differs: %+v", loaded)
+	}
+
+	if err := loaded.Check("", ""); err != nil {
+		t.Errorf("Expected empty values to be accepted, got %v", err)
+	}
+	if err := loaded.Check(config.ID, "hpke"); err != nil {
+		t.Errorf("Expected matching values to be accepted, got %v", err)
+	}
+	if err := loaded.Check("other", ""); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch for another ID, got %v", err)
+	}
+	if err := loaded.Check("", "aes"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected ErrMismatch for another encryption backend, got %v", err)
+	}
+
+	// Newer formats can't be read safely
+	loaded.Version = FormatVersion + 1
+	Save(backend, loaded)
+	if _, err := Load(backend); err == nil {
+		t.Error("Expected an error for a newer format version")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	config, err := NewConfig("repo", Encryption{Backend: "aes"})
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+
+	if namespace := config.Namespace("web-1"); namespace != "hosts/web-1/" {
+		t.Errorf("Unexpected namespace %q", namespace)
+	}
+	if config.BoundID("web-1") == config.BoundID("web-2") {
+		t.Error("Expected hosts to be bound to different IDs")
+	}
+
+	// Repositories from before namespaces keep their layout and IDs
+	config.Namespaces = false
+	if namespace := config.Namespace("web-1"); namespace != "" {
+		t.Errorf("Expected no namespace, got %q", namespace)
+	}
+	if id := config.BoundID("web-1"); id != "repo" {
+		t.Errorf("Expected the repository ID, got %q", id)
+	}
+
+	for _, host := range []string{"", ".", "..", "a/b", "a\\b"} {
+		if err := ValidateHost(host); err == nil {
+			t.Errorf("Expected %q to be refused", host)
+		}
+	}
+	if err := ValidateHost("web-1.example.com"); err != nil {
+		t.Errorf("Expected a hostname to be accepted, got %v", err)
+	}
+}
+
+func TestReserved(t *testing.T) {
+	for path, reserved := range map[string]bool{
+		".gosafe":                  true,
+		".gosafe/index/a.gosafe":   true,
+		".gosafe/locks/0123":       true,
+		"db.gosafe":                true,
+		"manifest.gosafe":          true,
+		"index/a.gosafe":           false,
+		"head.gosafe":              false,
+		"docs/.gosafe/index/a":     false,
+		".gosafe-quarantine/a.txt": false,
+		"etc/db.gosafe":            false,
+	} {
+		if Reserved(path) != reserved {
+			t.Errorf("Expected Reserved(%q) to be %v", path, reserved)
+		}
+	}
+
+	for p, temporary := range map[string]bool{
+		".gosafe-123456":       true,
+		"etc/.gosafe-123456":   true,
+		"etc/passwd":           false,
+		"etc/gosafe-123456":    false,
+		"etc/.gosafe/123456":   false,
+		".gosafe-quarantine/a": false,
+	} {
+		if Temporary(p) != temporary {
+			t.Errorf("Expected Temporary(%q) to be %v", p, temporary)
+		}
+	}
+
+	if key := ManifestKey(IndexPrefix + "20240101T000000.000000000Z.gosafe"); key != ".gosafe/manifest/20240101T000000.000000000Z.gosafe" {
+		t.Errorf("Unexpected manifest key %q", key)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	backend := storagetest.NewMemoryBackend()
+
+	if legacy, err := Legacy(backend); err != nil || legacy {
+		t.Errorf("Expected an empty repository not to be legacy, got %v, %v", legacy, err)
+	}
+
+	backend.Store("hosts/web-2/a.txt", 0, []byte("aaa"))
+	backend.Store("hosts/web-1/a.txt", 0, []byte("a"))
+	backend.Store("hosts/web-1/.gosafe/index/20240101T000000.000000000Z.gosafe", 0, []byte("{}"))
+	backend.Store("hosts/stray", 0, []byte("x"))
+
+	hosts, err := Hosts(backend)
+	if err != nil {
+		t.Fatalf("Failed to list hosts: %v", err)
+	}
+	if len(hosts) != 2 || hosts[0].Name != "web-1" || hosts[1].Name != "web-2" {
+		t.Fatalf("Unexpected hosts: %+v", hosts)
+	}
+	if hosts[0].Objects != 2 || hosts[0].Size != 3 || hosts[0].LastBackup.IsZero() {
+		t.Errorf("Unexpected summary of web-1: %+v", hosts[0])
+	}
+	if !hosts[1].LastBackup.IsZero() {
+		t.Errorf("Expected web-2 to have no index, got %v", hosts[1].LastBackup)
+	}
+
+	backend.Store("db.gosafe", 0, []byte("{}"))
+	if legacy, err := Legacy(backend); err != nil || !legacy {
+		t.Errorf("Expected a root index to be legacy, got %v, %v", legacy, err)
+	}
+}
@@ -1,19 +1,40 @@
 package storage
 
-import "github.com/yyewolf/go-safe/encryption"
+import (
+	"errors"
+	"time"
+)
 
 type Config interface {
 }
 
+// ErrNotFound is returned when retrieving an object which does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// StorageBackend represents a place to store objects. Storage backends only
+// move opaque bytes, compression and encryption are applied by wrapping them
+// in a transform pipeline.
 type StorageBackend interface {
 	// Initialize the backend with any necessary configuration.
-	Initialize(config Config, encryptionBackend encryption.EncryptionBackend) error
+	Initialize(config Config) error
+
+	// Store a file with the specified key and data.
+	// The version must be increased every time the key is overwritten.
+	Store(key string, version uint64, data []byte) error
 
-	// Store a file with the specified key and encrypted data.
-	Store(key string, data []byte) error
+	// Retrieve a file with the specified key and version and return its data.
+	// ErrNotFound is returned if there is no such file.
+	Retrieve(key string, version uint64) ([]byte, error)
 
-	// Retrieve a file with the specified key and return its decrypted data.
-	Retrieve(key string) ([]byte, error)
+	// List the files whose key starts with the specified prefix.
+	List(prefix string) ([]ObjectInfo, error)
 
 	// Delete a file with the specified key.
 	Delete(key string) error
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAppendOnly is returned when deleting or overwriting an object through an
+// append-only backend.
+var ErrAppendOnly = errors.New("append-only backend")
+
+// AppendOnlyConfig represents the configuration for the append-only backend.
+type AppendOnlyConfig struct {
+	Backend StorageBackend
+}
+
+// AppendOnlyBackend represents a backend which refuses to delete or overwrite
+// the objects of another backend, so that history can only grow.
+type AppendOnlyBackend struct {
+	backend StorageBackend
+}
+
+// NewAppendOnlyBackend creates a new append-only backend wrapping a storage backend.
+func NewAppendOnlyBackend(backend StorageBackend) (StorageBackend, error) {
+	b := AppendOnlyBackend{}
+	err := b.Initialize(&AppendOnlyConfig{Backend: backend})
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Initialize initializes the append-only backend with the wrapped backend.
+func (b *AppendOnlyBackend) Initialize(cfg Config) error {
+	config, ok := cfg.(*AppendOnlyConfig)
+	if !ok {
+		return errors.New("config is not of type AppendOnlyConfig")
+	}
+
+	if config.Backend == nil {
+		return errors.New("backend cannot be nil")
+	}
+
+	b.backend = config.Backend
+
+	return nil
+}
+
+// Store stores a file, unless an object already exists with the same key.
+func (b *AppendOnlyBackend) Store(key string, version uint64, data []byte) error {
+	objects, err := b.backend.List(key)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if object.Key == key {
+			return fmt.Errorf("%s already exists: %w", key, ErrAppendOnly)
+		}
+	}
+
+	return b.backend.Store(key, version, data)
+}
+
+// Retrieve retrieves a file from the wrapped backend.
+func (b *AppendOnlyBackend) Retrieve(key string, version uint64) ([]byte, error) {
+	return b.backend.Retrieve(key, version)
+}
+
+// List lists the files of the wrapped backend.
+func (b *AppendOnlyBackend) List(prefix string) ([]ObjectInfo, error) {
+	return b.backend.List(prefix)
+}
+
+// Delete always fails, files are only deleted by pruning from elsewhere.
+func (b *AppendOnlyBackend) Delete(key string) error {
+	return fmt.Errorf("%s cannot be deleted: %w", key, ErrAppendOnly)
+}
@@ -0,0 +1,46 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+func TestAppendOnlyBackend(t *testing.T) {
+	memory := storagetest.NewMemoryBackend()
+
+	b, err := storage.NewAppendOnlyBackend(memory)
+	if err != nil {
+		t.Fatalf("Failed to initialize append-only backend: %v", err)
+	}
+
+	if err := b.Store("docs/report.pdf", 1, []byte("first")); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+
+	// A key sharing the prefix of an existing one is a different object
+	if err := b.Store("docs/report", 1, []byte("other")); err != nil {
+		t.Fatalf("Failed to store a key prefixing another one: %v", err)
+	}
+
+	err = b.Store("docs/report.pdf", 2, []byte("second"))
+	if !errors.Is(err, storage.ErrAppendOnly) {
+		t.Errorf("Expected ErrAppendOnly when overwriting, got %v", err)
+	}
+
+	err = b.Delete("docs/report.pdf")
+	if !errors.Is(err, storage.ErrAppendOnly) {
+		t.Errorf("Expected ErrAppendOnly when deleting, got %v", err)
+	}
+
+	data, err := b.Retrieve("docs/report.pdf", 1)
+	if err != nil {
+		t.Fatalf("Failed to retrieve: %v", err)
+	}
+	if !bytes.Equal(data, []byte("first")) {
+		t.Errorf("Expected the first version to be kept, got %q", data)
+	}
+}
@@ -3,12 +3,14 @@ package storage
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/yyewolf/go-safe/encryption"
 )
 
 // S3Config represents the configuration for the S3 backend.
@@ -21,26 +23,25 @@ type S3Config struct {
 
 // S3Backend represents a backend that stores and retrieves files from Amazon S3.
 type S3Backend struct {
-	storageclass      string
-	prepend           string
-	bucket            string
-	config            *aws.Config
-	encryptionBackend encryption.EncryptionBackend
-	s3Client          *s3.S3
+	storageclass string
+	prepend      string
+	bucket       string
+	config       *aws.Config
+	s3Client     *s3.S3
 }
 
 // NewS3Backend creates a new instance of the S3Backend.
-func NewS3Backend(config *S3Config, encryptionBackend encryption.EncryptionBackend) (StorageBackend, error) {
+func NewS3Backend(config *S3Config) (StorageBackend, error) {
 	b := S3Backend{}
-	err := b.Initialize(config, encryptionBackend)
+	err := b.Initialize(config)
 	if err != nil {
 		return nil, err
 	}
 	return &b, nil
 }
 
-// Initialize initializes the S3 backend with the configuration and encryption backend.
-func (b *S3Backend) Initialize(cfg Config, encryptionBackend encryption.EncryptionBackend) error {
+// Initialize initializes the S3 backend with the configuration.
+func (b *S3Backend) Initialize(cfg Config) error {
 	config, ok := cfg.(*S3Config)
 	if !ok {
 		return errors.New("config is not of type S3Config")
@@ -69,26 +70,19 @@ func (b *S3Backend) Initialize(cfg Config, encryptionBackend encryption.Encrypti
 	b.prepend = config.Prepend
 	b.bucket = config.Bucket
 	b.config = config.Config
-	b.encryptionBackend = encryptionBackend
 
 	return nil
 }
 
-// Store stores a file in S3 with the specified key and encrypted data.
-func (b *S3Backend) Store(key string, data []byte) error {
-	// Encrypt the data using the encryption backend
-	encryptedData, err := b.encryptionBackend.Encrypt(data)
-	if err != nil {
-		return err
-	}
-
+// Store stores a file in S3 with the specified key and data.
+func (b *S3Backend) Store(key string, version uint64, data []byte) error {
 	key = filepath.Join(b.prepend, key)
 
-	// Upload the encrypted data to S3
-	_, err = b.s3Client.PutObject(&s3.PutObjectInput{
+	// Upload the data to S3
+	_, err := b.s3Client.PutObject(&s3.PutObjectInput{
 		Bucket:       aws.String(b.bucket),
 		Key:          aws.String(key),
-		Body:         bytes.NewReader(encryptedData),
+		Body:         bytes.NewReader(data),
 		StorageClass: aws.String(b.storageclass),
 	})
 	if err != nil {
@@ -98,38 +92,65 @@ func (b *S3Backend) Store(key string, data []byte) error {
 	return nil
 }
 
-// Retrieve retrieves a file from S3 with the specified key and returns its decrypted data.
-func (b *S3Backend) Retrieve(key string) ([]byte, error) {
+// Retrieve retrieves a file from S3 with the specified key and returns its data.
+func (b *S3Backend) Retrieve(key string, version uint64) ([]byte, error) {
 	key = filepath.Join(b.prepend, key)
-	// Download the encrypted data from S3
+	// Download the data from S3
 	resp, err := b.s3Client.GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(key),
 	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Read the encrypted data from the response
+	// Read the data from the response
 	buf := new(bytes.Buffer)
 	_, err = buf.ReadFrom(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	encryptedData := buf.Bytes()
 
-	// Decrypt the data using the encryption backend
-	decryptedData, err := b.encryptionBackend.Decrypt(encryptedData)
+	return buf.Bytes(), nil
+}
+
+// List lists the files in S3 whose key starts with the specified prefix.
+func (b *S3Backend) List(prefix string) ([]ObjectInfo, error) {
+	// Keys are returned relative to the S3 directory
+	base := ""
+	if b.prepend != "" {
+		base = strings.TrimSuffix(filepath.Join(b.prepend, "."), "/") + "/"
+	}
+
+	var objects []ObjectInfo
+	err := b.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(base + prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          strings.TrimPrefix(aws.StringValue(object.Key), base),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return decryptedData, nil
+	return objects, nil
 }
 
 // Delete deletes a file from S3 with the specified key.
 func (b *S3Backend) Delete(key string) error {
+	key = filepath.Join(b.prepend, key)
+
 	_, err := b.s3Client.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(key),
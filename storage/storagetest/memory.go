@@ -0,0 +1,104 @@
+// Package storagetest provides a storage backend for the tests of the
+// packages built on top of storage backends.
+package storagetest
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// MemoryBackend is a storage backend keeping objects in memory, safe for
+// concurrent use (e.g. lock heartbeats).
+type MemoryBackend struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+// NewMemoryBackend creates a new empty memory backend.
+func NewMemoryBackend() *MemoryBackend {
+	b := &MemoryBackend{}
+	b.Initialize(nil)
+	return b
+}
+
+// Initialize empties the backend.
+func (b *MemoryBackend) Initialize(config storage.Config) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects = make(map[string][]byte)
+	b.modified = make(map[string]time.Time)
+	return nil
+}
+
+// Store stores the data under the key, recording the time.
+func (b *MemoryBackend) Store(key string, version uint64, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects[key] = data
+	b.modified[key] = time.Now()
+	return nil
+}
+
+// Retrieve returns the data stored under the key.
+func (b *MemoryBackend) Retrieve(key string, version uint64) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return data, nil
+}
+
+// List returns the objects whose key starts with the prefix.
+func (b *MemoryBackend) List(prefix string) ([]storage.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var objects []storage.ObjectInfo
+	for key, data := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key, Size: int64(len(data)), LastModified: b.modified[key]})
+		}
+	}
+	return objects, nil
+}
+
+// Delete deletes the object stored under the key.
+func (b *MemoryBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, key)
+	delete(b.modified, key)
+	return nil
+}
+
+// Get returns the raw data stored under the key, bypassing any wrapper, so
+// that tests can inspect what was stored.
+func (b *MemoryBackend) Get(key string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.objects[key]
+}
+
+// Put stores raw data under the key, so that tests can tamper with objects.
+func (b *MemoryBackend) Put(key string, data []byte) {
+	b.Store(key, 0, data)
+}
+
+// Len returns the number of stored objects.
+func (b *MemoryBackend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.objects)
+}
@@ -0,0 +1,121 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Objects start with a magic holding the envelope version. Objects without
+// one were encrypted before envelopes existed, with no associated data.
+var (
+	// envelopeMagicV1 prefixes objects compressed then encrypted, bound to
+	// their key, repository and version.
+	envelopeMagicV1 = []byte("GSF\x01")
+	// envelopeMagicV2 prefixes objects listing the stages they went through.
+	envelopeMagicV2 = []byte("GSF\x02")
+	// envelopeMagicV3 prefixes objects recording their version, then listing
+	// the stages they went through.
+	envelopeMagicV3 = []byte("GSF\x03")
+)
+
+// envelopeV1Stages are the stages applied to every version 1 object.
+var envelopeV1Stages = []string{"compress", "encrypt"}
+
+// ErrNoVersion is returned for objects whose envelope doesn't record their version.
+var ErrNoVersion = errors.New("object does not record its version")
+
+// encodeEnvelope returns the header of a version 3 object, made of the magic,
+// the version of the object and the names of the stages in the order they
+// were applied. The version is in the clear, so that it doesn't need to be
+// guessed, and authenticated along the rest of the header.
+func encodeEnvelope(version uint64, stages []string) ([]byte, error) {
+	if len(stages) > 255 {
+		return nil, errors.New("too many transformers")
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(envelopeMagicV3)
+	binary.Write(buf, binary.BigEndian, version)
+	buf.WriteByte(byte(len(stages)))
+	for _, stage := range stages {
+		if len(stage) == 0 || len(stage) > 255 {
+			return nil, errors.New("invalid transformer name")
+		}
+		buf.WriteByte(byte(len(stage)))
+		buf.WriteString(stage)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeEnvelope returns the header, the stages and the payload of a version
+// 2 or 3 object.
+func decodeEnvelope(data []byte) ([]byte, []string, []byte, error) {
+	offset := len(envelopeMagicV2)
+	if bytes.HasPrefix(data, envelopeMagicV3) {
+		offset += 8
+	} else if !bytes.HasPrefix(data, envelopeMagicV2) {
+		return nil, nil, nil, errors.New("invalid envelope")
+	}
+	if len(data) < offset+1 {
+		return nil, nil, nil, errors.New("invalid envelope")
+	}
+
+	count := int(data[offset])
+	offset++
+
+	stages := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if offset >= len(data) {
+			return nil, nil, nil, errors.New("envelope is truncated")
+		}
+		size := int(data[offset])
+		offset++
+		if size == 0 || offset+size > len(data) {
+			return nil, nil, nil, errors.New("envelope is truncated")
+		}
+		stages = append(stages, string(data[offset:offset+size]))
+		offset += size
+	}
+
+	return data[:offset], stages, data[offset:], nil
+}
+
+// envelopeVersion returns the version recorded in the envelope of a version 3
+// object, and whether there is one.
+func envelopeVersion(data []byte) (uint64, bool) {
+	if !bytes.HasPrefix(data, envelopeMagicV3) || len(data) < len(envelopeMagicV3)+8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data[len(envelopeMagicV3):]), true
+}
+
+// Version returns the version of a stored object, read from its envelope
+// without decoding it. Objects stored before envelopes recorded their version
+// have none, and ErrNoVersion is returned.
+func Version(data []byte) (uint64, error) {
+	version, ok := envelopeVersion(data)
+	if !ok {
+		return 0, ErrNoVersion
+	}
+	return version, nil
+}
+
+// associatedData returns the data an object is bound to, so that it can't be
+// moved to another key or repository, rolled back to an older version, or
+// have its envelope altered, without failing decryption.
+func associatedData(header []byte, repositoryID string, key string, version uint64) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(header)
+	writeField(buf, []byte(repositoryID))
+	writeField(buf, []byte(key))
+	binary.Write(buf, binary.BigEndian, version)
+	return buf.Bytes()
+}
+
+// writeField writes a length-prefixed field, so that fields can't be shifted into each other.
+func writeField(buf *bytes.Buffer, field []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(field)))
+	buf.Write(field)
+}
@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// metadataStage is the name of the built-in stage embedding the metadata of
+// the file in the object.
+const metadataStage = "meta"
+
+// Metadata describes the file stored in an object, so that the index can be
+// rebuilt from the objects alone, even when their keys are opaque.
+type Metadata struct {
+	Path    string      `json:"p"`
+	Sum     string      `json:"s"`
+	Size    int64       `json:"z"`
+	ModTime int64       `json:"m"`
+	Mode    os.FileMode `json:"o"`
+}
+
+// metadataTransformer represents the stage prefixing the data with the
+// metadata of the file. It comes first, so that the metadata is compressed,
+// padded and encrypted along the content.
+type metadataTransformer struct{}
+
+// Name returns the name of the stage.
+func (t metadataTransformer) Name() string {
+	return metadataStage
+}
+
+// Forward prefixes the data with the length of the metadata and the metadata.
+func (t metadataTransformer) Forward(object *Object, data []byte) ([]byte, error) {
+	if object.Metadata == nil {
+		return nil, errors.New("no metadata")
+	}
+
+	metadata, err := json.Marshal(object.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 4, 4+len(metadata)+len(data))
+	binary.BigEndian.PutUint32(out, uint32(len(metadata)))
+	out = append(out, metadata...)
+	return append(out, data...), nil
+}
+
+// Reverse strips the metadata from the data, recording it in the object.
+func (t metadataTransformer) Reverse(object *Object, data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("data too short")
+	}
+
+	size := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(size) > uint64(len(data)) {
+		return nil, errors.New("invalid metadata length")
+	}
+
+	metadata := &Metadata{}
+	if err := json.Unmarshal(data[:size], metadata); err != nil {
+		return nil, err
+	}
+	object.Metadata = metadata
+
+	return data[size:], nil
+}
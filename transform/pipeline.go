@@ -0,0 +1,258 @@
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/yyewolf/go-safe/storage"
+)
+
+// PipelineConfig represents the configuration for a transform pipeline.
+type PipelineConfig struct {
+	// Backend stores the transformed objects.
+	Backend storage.StorageBackend
+	// RepositoryID is bound to every object, so that objects can't be moved
+	// between repositories sharing the same keys.
+	RepositoryID string
+	// Transformers are applied in order before storing, and in reverse order
+	// after retrieving.
+	Transformers []Transformer
+	// Required are the names of the stages every retrieved object must have
+	// gone through, e.g. "sign" to refuse unsigned objects. The encryption
+	// stage is always required.
+	Required []string
+	// AllowLegacy accepts the objects stored before envelopes, which are
+	// encrypted without being bound to their key, repository and version.
+	AllowLegacy bool
+}
+
+// Pipeline represents a storage backend applying transformers to objects
+// (e.g. compress, pad, encrypt, sign) before handing them to another storage
+// backend, which only moves opaque bytes.
+type Pipeline struct {
+	backend      storage.StorageBackend
+	repositoryID string
+	transformers []Transformer
+	required     []string
+	allowLegacy  bool
+}
+
+// NewPipeline creates a new transform pipeline wrapping a storage backend.
+func NewPipeline(backend storage.StorageBackend, repositoryID string, transformers ...Transformer) (*Pipeline, error) {
+	p := Pipeline{}
+	err := p.Initialize(&PipelineConfig{
+		Backend:      backend,
+		RepositoryID: repositoryID,
+		Transformers: transformers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Initialize initializes the pipeline with the wrapped backend and the transformers.
+func (p *Pipeline) Initialize(cfg storage.Config) error {
+	config, ok := cfg.(*PipelineConfig)
+	if !ok {
+		return errors.New("config is not of type PipelineConfig")
+	}
+
+	if config.Backend == nil {
+		return errors.New("backend cannot be nil")
+	}
+
+	// Names must be unique, they are used to find the stages to reverse
+	names := map[string]bool{metadataStage: true}
+	for _, transformer := range config.Transformers {
+		if names[transformer.Name()] {
+			return fmt.Errorf("duplicate transformer %q", transformer.Name())
+		}
+		names[transformer.Name()] = true
+	}
+
+	p.backend = config.Backend
+	p.repositoryID = config.RepositoryID
+	p.transformers = config.Transformers
+	// The stages are listed in the clear, an object claiming not to be
+	// encrypted could have been forged by anyone able to write to the bucket
+	p.required = append([]string{encryptStage}, config.Required...)
+	p.allowLegacy = config.AllowLegacy
+
+	return nil
+}
+
+// Store transforms the data and stores it with its envelope.
+func (p *Pipeline) Store(key string, version uint64, data []byte) error {
+	return p.StoreFile(key, version, data, nil)
+}
+
+// StoreFile transforms the content of a file and stores it with its envelope,
+// embedding the metadata of the file if given.
+func (p *Pipeline) StoreFile(key string, version uint64, data []byte, metadata *Metadata) error {
+	transformers := p.transformers
+	if metadata != nil {
+		transformers = append([]Transformer{metadataTransformer{}}, transformers...)
+	}
+
+	stages := make([]string, 0, len(transformers))
+	for _, transformer := range transformers {
+		stages = append(stages, transformer.Name())
+	}
+
+	header, err := encodeEnvelope(version, stages)
+	if err != nil {
+		return err
+	}
+
+	object := &Object{
+		RepositoryID:   p.repositoryID,
+		Key:            key,
+		Version:        version,
+		AssociatedData: associatedData(header, p.repositoryID, key, version),
+		Metadata:       metadata,
+	}
+
+	for _, transformer := range transformers {
+		data, err = transformer.Forward(object, data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", transformer.Name(), err)
+		}
+	}
+
+	return p.backend.Store(key, version, append(header, data...))
+}
+
+// Retrieve retrieves the data and reverses the stages listed in its envelope.
+func (p *Pipeline) Retrieve(key string, version uint64) ([]byte, error) {
+	data, _, err := p.RetrieveFile(key, version)
+	return data, err
+}
+
+// RetrieveFile retrieves the content of a file along its metadata, which is
+// nil if it was stored without.
+func (p *Pipeline) RetrieveFile(key string, version uint64) ([]byte, *Metadata, error) {
+	data, err := p.backend.Retrieve(key, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.Decode(key, version, data)
+}
+
+// RetrieveVersion retrieves an object whose version is unknown, e.g. a
+// generation of the index, returning the version recorded in its envelope.
+// Objects which don't record it are decoded as version 0. The caller is left
+// to check that the version is the expected one.
+func (p *Pipeline) RetrieveVersion(key string) ([]byte, uint64, error) {
+	data, err := p.backend.Retrieve(key, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, _ := envelopeVersion(data)
+	content, _, err := p.Decode(key, version, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return content, version, nil
+}
+
+// Decode reverses the stages of data retrieved from the wrapped backend,
+// returning the content and the metadata of the file.
+func (p *Pipeline) Decode(key string, version uint64, data []byte) ([]byte, *Metadata, error) {
+	var err error
+	object := &Object{
+		RepositoryID: p.repositoryID,
+		Key:          key,
+		Version:      version,
+	}
+
+	var stages []string
+	switch {
+	case bytes.HasPrefix(data, envelopeMagicV2), bytes.HasPrefix(data, envelopeMagicV3):
+		if recorded, ok := envelopeVersion(data); ok && recorded != version {
+			return nil, nil, fmt.Errorf("object is version %d, not %d", recorded, version)
+		}
+		var header []byte
+		header, stages, data, err = decodeEnvelope(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		object.AssociatedData = associatedData(header, p.repositoryID, key, version)
+	case bytes.HasPrefix(data, envelopeMagicV1):
+		stages = envelopeV1Stages
+		data = data[len(envelopeMagicV1):]
+		object.AssociatedData = associatedData(envelopeMagicV1, p.repositoryID, key, version)
+	default:
+		// Objects stored before envelopes are only encrypted, and can only be
+		// the first version of a key. Being bound to nothing, any of them could
+		// be copied over any other object, so they are refused unless allowed.
+		if !p.allowLegacy {
+			return nil, nil, errors.New("object is not bound to its key, repository and version (stored before envelopes), only restored with --allow-legacy")
+		}
+		if version != 0 {
+			return nil, nil, errors.New("object is not bound to its key and version")
+		}
+		stages = []string{"encrypt"}
+	}
+
+	for _, required := range p.required {
+		found := false
+		for _, stage := range stages {
+			found = found || stage == required
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("object did not go through the required %q stage", required)
+		}
+	}
+
+	for i := len(stages) - 1; i >= 0; i-- {
+		transformer := p.transformer(stages[i])
+		if transformer == nil {
+			return nil, nil, fmt.Errorf("no %q transformer configured to restore the object", stages[i])
+		}
+
+		data, err = transformer.Reverse(object, data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", transformer.Name(), err)
+		}
+	}
+
+	return data, object.Metadata, nil
+}
+
+// List lists the objects of the wrapped backend.
+func (p *Pipeline) List(prefix string) ([]storage.ObjectInfo, error) {
+	return p.backend.List(prefix)
+}
+
+// AllowLegacy makes the pipeline accept the objects stored before envelopes.
+func (p *Pipeline) AllowLegacy() {
+	p.allowLegacy = true
+}
+
+// Require makes the pipeline refuse retrieved objects which did not go through the given stages.
+func (p *Pipeline) Require(stages ...string) {
+	p.required = append(p.required, stages...)
+}
+
+// Delete deletes the object from the wrapped backend.
+func (p *Pipeline) Delete(key string) error {
+	return p.backend.Delete(key)
+}
+
+// transformer returns the configured transformer with the given name.
+func (p *Pipeline) transformer(name string) Transformer {
+	if name == metadataStage {
+		return metadataTransformer{}
+	}
+	for _, transformer := range p.transformers {
+		if transformer.Name() == name {
+			return transformer
+		}
+	}
+	return nil
+}
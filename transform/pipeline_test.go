@@ -0,0 +1,269 @@
+package transform
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/yyewolf/go-safe/compression"
+	"github.com/yyewolf/go-safe/encryption"
+	"github.com/yyewolf/go-safe/storage"
+	"github.com/yyewolf/go-safe/storage/storagetest"
+)
+
+func newTestPipeline(t *testing.T) (*Pipeline, *storagetest.MemoryBackend, encryption.EncryptionBackend) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate random key: %v", err)
+	}
+	encryptionBackend, err := encryption.NewAESEncryptionBackend(key)
+	if err != nil {
+		t.Fatalf("Failed to initialize encryption backend: %v", err)
+	}
+
+	compressor, err := compression.NewCompressor(compression.AlgorithmZstd, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize compressor: %v", err)
+	}
+
+	backend := storagetest.NewMemoryBackend()
+
+	p, err := NewPipeline(backend, "repository", NewCompressTransformer(compressor), NewEncryptTransformer(encryptionBackend))
+	if err != nil {
+		t.Fatalf("Failed to initialize pipeline: %v", err)
+	}
+
+	return p, backend, encryptionBackend
+}
+
+func TestPipeline(t *testing.T) {
+	p, backend, _ := newTestPipeline(t)
+
+	data := []byte(strings.Repeat("This is a small file.\n", 100))
+	if err := p.Store("etc/passwd", 1, data); err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+
+	// The stored object is an envelope listing the stages
+	stored := backend.Get("etc/passwd")
+	_, stages, _, err := decodeEnvelope(stored)
+	if err != nil {
+		t.Fatalf("Failed to decode envelope: %v", err)
+	}
+	if strings.Join(stages, ",") != "compress,encrypt" {
+		t.Fatalf("Unexpected stages: %v", stages)
+	}
+	if len(stored) >= len(data) {
+		t.Fatal("Object was not compressed")
+	}
+
+	retrieved, err := p.Retrieve("etc/passwd", 1)
+	if err != nil {
+		t.Fatalf("Failed to retrieve object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Object storage and retrieval failed: data mismatch")
+	}
+
+	// Missing objects are reported as such
+	if _, err := p.Retrieve("etc/missing", 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("Unexpected error for a missing object: %v", err)
+	}
+
+	// An object moved to another key must be rejected
+	backend.Put("etc/shadow", stored)
+	if _, err := p.Retrieve("etc/shadow", 1); err == nil {
+		t.Fatal("Object was retrieved from another key")
+	}
+
+	// An older version must be rejected
+	if err := p.Store("etc/passwd", 2, []byte("This is a newer file.")); err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	backend.Put("etc/passwd", stored)
+	if _, err := p.Retrieve("etc/passwd", 2); err == nil {
+		t.Fatal("Object was rolled back to an older version")
+	}
+
+	// An altered envelope must be rejected
+	tampered := bytes.Replace(stored, []byte("compress"), []byte("compresz"), 1)
+	backend.Put("etc/passwd", tampered)
+	if _, err := p.Retrieve("etc/passwd", 1); err == nil {
+		t.Fatal("Object with an altered envelope was retrieved")
+	}
+
+	// The same object in another repository must be rejected
+	other, err := NewPipeline(backend, "other", p.transformers...)
+	if err != nil {
+		t.Fatalf("Failed to initialize pipeline: %v", err)
+	}
+	backend.Put("etc/passwd", stored)
+	if _, err := other.Retrieve("etc/passwd", 1); err == nil {
+		t.Fatal("Object was retrieved from another repository")
+	}
+
+	// Duplicate stages can't be reversed unambiguously
+	if _, err := NewPipeline(backend, "", p.transformers[0], p.transformers[0]); err == nil {
+		t.Fatal("Pipeline with duplicate stages was created")
+	}
+}
+
+func TestPipelineLegacyObjects(t *testing.T) {
+	p, backend, encryptionBackend := newTestPipeline(t)
+	data := []byte("This is a small file.")
+
+	// Objects encrypted without associated data nor envelope
+	legacy, err := encryptionBackend.Encrypt(data, nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt legacy object: %v", err)
+	}
+	backend.Put("legacy", legacy)
+
+	// They are bound to nothing, so they are refused unless allowed
+	if _, err := p.Retrieve("legacy", 0); err == nil {
+		t.Fatal("Legacy object was retrieved without being allowed")
+	}
+	p.AllowLegacy()
+
+	retrieved, err := p.Retrieve("legacy", 0)
+	if err != nil {
+		t.Fatalf("Failed to retrieve legacy object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Legacy object retrieval failed: data mismatch")
+	}
+	if _, err := p.Retrieve("legacy", 1); err == nil {
+		t.Fatal("Legacy object was retrieved as a newer version")
+	}
+
+	// Objects compressed then encrypted behind the first envelope version
+	compressor, err := compression.NewCompressor(compression.AlgorithmGzip, 0)
+	if err != nil {
+		t.Fatalf("Failed to initialize compressor: %v", err)
+	}
+	compressed, err := compressor.Compress(data)
+	if err != nil {
+		t.Fatalf("Failed to compress object: %v", err)
+	}
+	encrypted, err := encryptionBackend.Encrypt(compressed, associatedData(envelopeMagicV1, "repository", "v1", 3))
+	if err != nil {
+		t.Fatalf("Failed to encrypt object: %v", err)
+	}
+	backend.Put("v1", append(append([]byte{}, envelopeMagicV1...), encrypted...))
+	retrieved, err = p.Retrieve("v1", 3)
+	if err != nil {
+		t.Fatalf("Failed to retrieve version 1 object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Version 1 object retrieval failed: data mismatch")
+	}
+
+	// Objects listing their stages, without their version
+	header := append(append([]byte{}, envelopeMagicV2...), 1, byte(len("encrypt")))
+	header = append(header, "encrypt"...)
+	encrypted, err = encryptionBackend.Encrypt(data, associatedData(header, "repository", "v2", 4))
+	if err != nil {
+		t.Fatalf("Failed to encrypt object: %v", err)
+	}
+	backend.Put("v2", append(header, encrypted...))
+	retrieved, err = p.Retrieve("v2", 4)
+	if err != nil {
+		t.Fatalf("Failed to retrieve version 2 object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Version 2 object retrieval failed: data mismatch")
+	}
+	if _, err := Version(backend.Get("v2")); !errors.Is(err, ErrNoVersion) {
+		t.Fatalf("Expected ErrNoVersion for a version 2 object, got %v", err)
+	}
+}
+
+func TestPipelineUnencryptedObjects(t *testing.T) {
+	p, backend, _ := newTestPipeline(t)
+	forged := []byte("ssh-ed25519 ATTACKER")
+
+	// Envelopes are in the clear, anyone able to write to the bucket can
+	// claim that an object skipped encryption
+	for name, envelope := range map[string][]byte{
+		"no stage":      append([]byte("GSF\x02\x00"), forged...),
+		"compress only": append([]byte("GSF\x02\x01\x08compress"), forged...),
+	} {
+		backend.Put(".ssh/authorized_keys", envelope)
+		if data, err := p.Retrieve(".ssh/authorized_keys", 1); err == nil {
+			t.Errorf("Unencrypted object with %s was retrieved: %q", name, data)
+		}
+	}
+}
+
+func TestPipelineMetadata(t *testing.T) {
+	p, backend, _ := newTestPipeline(t)
+
+	data := []byte("127.0.0.1 localhost\n")
+	metadata := &Metadata{
+		Path:    "etc/hosts",
+		Sum:     "a3b2c1",
+		Size:    int64(len(data)),
+		ModTime: 1686663420,
+		Mode:    0644,
+	}
+	if err := p.StoreFile("4f/2a9c", 3, data, metadata); err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+
+	_, stages, _, err := decodeEnvelope(backend.Get("4f/2a9c"))
+	if err != nil {
+		t.Fatalf("Failed to decode envelope: %v", err)
+	}
+	if strings.Join(stages, ",") != "meta,compress,encrypt" {
+		t.Fatalf("Unexpected stages: %v", stages)
+	}
+
+	// The metadata is encrypted along the content
+	if bytes.Contains(backend.Get("4f/2a9c"), []byte("etc/hosts")) {
+		t.Fatal("Metadata is stored in the clear")
+	}
+
+	retrieved, retrievedMetadata, err := p.RetrieveFile("4f/2a9c", 3)
+	if err != nil {
+		t.Fatalf("Failed to retrieve object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Object storage and retrieval failed: data mismatch")
+	}
+	if retrievedMetadata == nil || *retrievedMetadata != *metadata {
+		t.Fatalf("Unexpected metadata: %+v", retrievedMetadata)
+	}
+
+	// Plain retrieval only returns the content
+	retrieved, err = p.Retrieve("4f/2a9c", 3)
+	if err != nil || !bytes.Equal(data, retrieved) {
+		t.Fatalf("Failed to retrieve object without its metadata: %v", err)
+	}
+
+	// Objects stored without metadata have none
+	if err := p.Store("etc/passwd", 1, data); err != nil {
+		t.Fatalf("Failed to store object: %v", err)
+	}
+	if _, retrievedMetadata, err = p.RetrieveFile("etc/passwd", 1); err != nil || retrievedMetadata != nil {
+		t.Fatalf("Unexpected metadata %+v: %v", retrievedMetadata, err)
+	}
+
+	// Decoding with the wrong version fails, so that versions can be searched
+	if _, _, err := p.Decode("4f/2a9c", 2, backend.Get("4f/2a9c")); err == nil {
+		t.Fatal("Object was decoded with the wrong version")
+	}
+
+	// The version is readable without decoding the object
+	if version, err := Version(backend.Get("4f/2a9c")); err != nil || version != 3 {
+		t.Fatalf("Unexpected version %d: %v", version, err)
+	}
+
+	// Altering the version fails decryption, even when decoded with it
+	tampered := append([]byte{}, backend.Get("4f/2a9c")...)
+	tampered[len(envelopeMagicV3)+7] = 2
+	if _, _, err := p.Decode("4f/2a9c", 2, tampered); err == nil {
+		t.Fatal("Object with an altered version was decoded")
+	}
+}
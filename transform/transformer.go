@@ -0,0 +1,30 @@
+package transform
+
+// Object describes the object being transformed.
+type Object struct {
+	// RepositoryID, Key and Version identify the object.
+	RepositoryID string
+	Key          string
+	Version      uint64
+
+	// AssociatedData binds the object to its identity and envelope. It is nil
+	// for objects stored before the binding existed.
+	AssociatedData []byte
+
+	// Metadata describes the file stored in the object, when it was stored
+	// with its metadata.
+	Metadata *Metadata
+}
+
+// Transformer represents a stage applied to objects before they are stored,
+// and reversed after they are retrieved.
+type Transformer interface {
+	// Name identifies the transformer in the envelope of stored objects.
+	Name() string
+
+	// Forward transforms the data before it is stored.
+	Forward(object *Object, data []byte) ([]byte, error)
+
+	// Reverse undoes Forward after the data is retrieved.
+	Reverse(object *Object, data []byte) ([]byte, error)
+}
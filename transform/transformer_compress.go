@@ -0,0 +1,31 @@
+package transform
+
+import "github.com/yyewolf/go-safe/compression"
+
+// CompressTransformer represents a stage compressing the data.
+type CompressTransformer struct {
+	compressor *compression.Compressor
+}
+
+// NewCompressTransformer creates a new compression stage. The algorithm is
+// recorded in the data itself, so any compression stage can reverse it.
+func NewCompressTransformer(compressor *compression.Compressor) *CompressTransformer {
+	return &CompressTransformer{
+		compressor: compressor,
+	}
+}
+
+// Name returns the name of the stage.
+func (t *CompressTransformer) Name() string {
+	return "compress"
+}
+
+// Forward compresses the data.
+func (t *CompressTransformer) Forward(object *Object, data []byte) ([]byte, error) {
+	return t.compressor.Compress(data)
+}
+
+// Reverse decompresses the data with the algorithm recorded in its header.
+func (t *CompressTransformer) Reverse(object *Object, data []byte) ([]byte, error) {
+	return compression.Decompress(data)
+}
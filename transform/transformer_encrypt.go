@@ -0,0 +1,35 @@
+package transform
+
+import "github.com/yyewolf/go-safe/encryption"
+
+// encryptStage is the name of the encryption stage, which every object must
+// have gone through.
+const encryptStage = "encrypt"
+
+// EncryptTransformer represents a stage encrypting the data, bound to the
+// object identity and envelope.
+type EncryptTransformer struct {
+	encryptionBackend encryption.EncryptionBackend
+}
+
+// NewEncryptTransformer creates a new encryption stage.
+func NewEncryptTransformer(encryptionBackend encryption.EncryptionBackend) *EncryptTransformer {
+	return &EncryptTransformer{
+		encryptionBackend: encryptionBackend,
+	}
+}
+
+// Name returns the name of the stage.
+func (t *EncryptTransformer) Name() string {
+	return encryptStage
+}
+
+// Forward encrypts the data.
+func (t *EncryptTransformer) Forward(object *Object, data []byte) ([]byte, error) {
+	return t.encryptionBackend.Encrypt(data, object.AssociatedData)
+}
+
+// Reverse decrypts the data.
+func (t *EncryptTransformer) Reverse(object *Object, data []byte) ([]byte, error) {
+	return t.encryptionBackend.Decrypt(data, object.AssociatedData)
+}
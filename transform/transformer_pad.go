@@ -0,0 +1,100 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// PaddingScheme represents the way padded sizes are chosen.
+type PaddingScheme string
+
+const (
+	// PaddingPadme pads to the Padmé sizes, leaking O(log log n) bits of the
+	// size for at most 12% overhead.
+	PaddingPadme PaddingScheme = "padme"
+	// PaddingPowerOfTwo pads to the next power of two, leaking O(log n) bits
+	// of the size for at most 100% overhead.
+	PaddingPowerOfTwo PaddingScheme = "pow2"
+)
+
+// PadTransformer represents a stage hiding the size of the data. It must be
+// followed by an encryption stage.
+type PadTransformer struct {
+	scheme PaddingScheme
+}
+
+// NewPadTransformer creates a new padding stage.
+func NewPadTransformer(scheme PaddingScheme) (*PadTransformer, error) {
+	switch scheme {
+	case PaddingPadme, PaddingPowerOfTwo:
+	default:
+		return nil, fmt.Errorf("unknown padding scheme %q", scheme)
+	}
+
+	return &PadTransformer{
+		scheme: scheme,
+	}, nil
+}
+
+// Name returns the name of the stage, which records the padding scheme.
+func (t *PadTransformer) Name() string {
+	return "pad-" + string(t.scheme)
+}
+
+// Forward appends a 0x80 byte to the data, then zeroes up to the padded size.
+func (t *PadTransformer) Forward(object *Object, data []byte) ([]byte, error) {
+	size := uint64(len(data)) + 1
+
+	var padded uint64
+	switch t.scheme {
+	case PaddingPadme:
+		padded = padme(size)
+	case PaddingPowerOfTwo:
+		padded = powerOfTwo(size)
+	}
+
+	out := make([]byte, padded)
+	copy(out, data)
+	out[len(data)] = 0x80
+
+	return out, nil
+}
+
+// Reverse removes the trailing zeroes and the 0x80 byte.
+func (t *PadTransformer) Reverse(object *Object, data []byte) ([]byte, error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0x00:
+			continue
+		case 0x80:
+			return data[:i], nil
+		}
+		break
+	}
+
+	return nil, errors.New("invalid padding")
+}
+
+// padme returns the Padmé padded size of a length, as described in "Reducing
+// Metadata Leakage from Encrypted Files and Communication with PURBs".
+func padme(length uint64) uint64 {
+	if length < 2 {
+		return length
+	}
+
+	e := uint64(bits.Len64(length) - 1)
+	s := uint64(bits.Len64(e))
+	mask := uint64(1)<<(e-s) - 1
+
+	return (length + mask) &^ mask
+}
+
+// powerOfTwo returns the smallest power of two greater or equal to a length.
+func powerOfTwo(length uint64) uint64 {
+	if length < 2 {
+		return length
+	}
+
+	return 1 << bits.Len64(length-1)
+}
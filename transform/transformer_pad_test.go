@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPadTransformer(t *testing.T) {
+	tests := []struct {
+		scheme PaddingScheme
+		sizes  map[int]int
+	}{
+		// Sizes include the 0x80 byte
+		{PaddingPadme, map[int]int{0: 1, 8: 10, 100: 104, 1000: 1024, 1_000_000: 1_015_808}},
+		{PaddingPowerOfTwo, map[int]int{0: 1, 8: 16, 100: 128, 1000: 1024, 1_000_000: 1_048_576}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scheme), func(t *testing.T) {
+			transformer, err := NewPadTransformer(tt.scheme)
+			if err != nil {
+				t.Fatalf("Failed to initialize padding: %v", err)
+			}
+
+			for size, paddedSize := range tt.sizes {
+				// Trailing zeroes must survive padding
+				data := bytes.Repeat([]byte{0x80, 0x00}, size/2)
+				if size%2 == 1 {
+					data = append(data, 0x80)
+				}
+
+				padded, err := transformer.Forward(&Object{}, data)
+				if err != nil {
+					t.Fatalf("Failed to pad %d bytes: %v", size, err)
+				}
+				if len(padded) != paddedSize {
+					t.Fatalf("%d bytes padded to %d bytes, expected %d", size, len(padded), paddedSize)
+				}
+
+				unpadded, err := transformer.Reverse(&Object{}, padded)
+				if err != nil {
+					t.Fatalf("Failed to unpad %d bytes: %v", size, err)
+				}
+				if !bytes.Equal(data, unpadded) {
+					t.Fatalf("Padding of %d bytes failed: data mismatch", size)
+				}
+			}
+		})
+	}
+
+	transformer, err := NewPadTransformer(PaddingPadme)
+	if err != nil {
+		t.Fatalf("Failed to initialize padding: %v", err)
+	}
+	if _, err := transformer.Reverse(&Object{}, []byte{0x01, 0x00, 0x00}); err == nil {
+		t.Fatal("Invalid padding was removed")
+	}
+	if _, err := NewPadTransformer("pow3"); err == nil {
+		t.Fatal("Unknown padding scheme was accepted")
+	}
+}
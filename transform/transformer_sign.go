@@ -0,0 +1,141 @@
+package transform
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// signatureContext separates the signatures of objects from any other use of the key.
+var signatureContext = []byte("go-safe object signature\x00")
+
+// SignTransformer represents a stage signing the data with Ed25519, along with
+// the object identity and envelope. It must be the last stage, so that
+// anything stored is authenticated before being processed.
+type SignTransformer struct {
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewSignTransformer creates a new signing stage, able to sign and verify.
+func NewSignTransformer(privateKey ed25519.PrivateKey) (*SignTransformer, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid Ed25519 private key")
+	}
+
+	return &SignTransformer{
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewVerifyTransformer creates a new signing stage, only able to verify.
+func NewVerifyTransformer(publicKey ed25519.PublicKey) (*SignTransformer, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	return &SignTransformer{
+		publicKey: publicKey,
+	}, nil
+}
+
+// Name returns the name of the stage.
+func (t *SignTransformer) Name() string {
+	return "sign"
+}
+
+// Forward appends the signature to the data.
+func (t *SignTransformer) Forward(object *Object, data []byte) ([]byte, error) {
+	if t.privateKey == nil {
+		return nil, errors.New("no Ed25519 private key configured")
+	}
+
+	signature := ed25519.Sign(t.privateKey, signedMessage(object, data))
+	return append(data, signature...), nil
+}
+
+// Reverse verifies and removes the signature.
+func (t *SignTransformer) Reverse(object *Object, data []byte) ([]byte, error) {
+	if len(data) < ed25519.SignatureSize {
+		return nil, errors.New("signature is missing")
+	}
+
+	data, signature := data[:len(data)-ed25519.SignatureSize], data[len(data)-ed25519.SignatureSize:]
+	if !ed25519.Verify(t.publicKey, signedMessage(object, data), signature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return data, nil
+}
+
+func signedMessage(object *Object, data []byte) []byte {
+	message := make([]byte, 0, len(signatureContext)+len(object.AssociatedData)+len(data))
+	message = append(message, signatureContext...)
+	message = append(message, object.AssociatedData...)
+	return append(message, data...)
+}
+
+// GenerateSigningKeyPair generates a PEM encoded Ed25519 key pair, in the
+// PKIX and PKCS #8 formats understood by OpenSSL.
+func GenerateSigningKeyPair() ([]byte, []byte, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		nil
+}
+
+// ParseSigningPrivateKey parses a PEM encoded PKCS #8 Ed25519 private key.
+func ParseSigningPrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("invalid PEM private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an Ed25519 key")
+	}
+
+	return privateKey, nil
+}
+
+// ParseSigningPublicKey parses a PEM encoded PKIX Ed25519 public key.
+func ParseSigningPublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("invalid PEM public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an Ed25519 key")
+	}
+
+	return publicKey, nil
+}
@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSignTransformer(t *testing.T) {
+	publicPEM, privatePEM, err := GenerateSigningKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate signing key pair: %v", err)
+	}
+	privateKey, err := ParseSigningPrivateKey(privatePEM)
+	if err != nil {
+		t.Fatalf("Failed to parse signing private key: %v", err)
+	}
+	publicKey, err := ParseSigningPublicKey(publicPEM)
+	if err != nil {
+		t.Fatalf("Failed to parse signing public key: %v", err)
+	}
+	if _, err := ParseSigningPublicKey(privatePEM); err == nil {
+		t.Fatal("Private key was parsed as a public key")
+	}
+
+	signer, err := NewSignTransformer(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to initialize signer: %v", err)
+	}
+	verifier, err := NewVerifyTransformer(publicKey)
+	if err != nil {
+		t.Fatalf("Failed to initialize verifier: %v", err)
+	}
+
+	// The backup host signs, the retriever only verifies
+	p, backend, encryptionBackend := newTestPipeline(t)
+	signing, err := NewPipeline(backend, "repository", append(p.transformers, signer)...)
+	if err != nil {
+		t.Fatalf("Failed to initialize pipeline: %v", err)
+	}
+	verifying, err := NewPipeline(backend, "repository", append(p.transformers, verifier)...)
+	if err != nil {
+		t.Fatalf("Failed to initialize pipeline: %v", err)
+	}
+	verifying.Require("sign")
+
+	data := []byte("This is a small file.")
+	if err := signing.Store("etc/passwd", 1, data); err != nil {
+		t.Fatalf("Failed to store signed object: %v", err)
+	}
+	retrieved, err := verifying.Retrieve("etc/passwd", 1)
+	if err != nil {
+		t.Fatalf("Failed to retrieve signed object: %v", err)
+	}
+	if !bytes.Equal(data, retrieved) {
+		t.Fatal("Signed object storage and retrieval failed: data mismatch")
+	}
+
+	// Tampered objects must be rejected
+	stored := backend.Get("etc/passwd")
+	tampered := append([]byte{}, stored...)
+	tampered[len(tampered)-10] ^= 0x01 // Inside the signature
+	backend.Put("etc/passwd", tampered)
+	if _, err := verifying.Retrieve("etc/passwd", 1); err == nil {
+		t.Fatal("Tampered signed object was retrieved")
+	}
+
+	// Objects forged with the encryption key alone must be rejected
+	if err := p.Store("etc/passwd", 1, []byte("This is a forged file.")); err != nil {
+		t.Fatalf("Failed to store unsigned object: %v", err)
+	}
+	if _, err := verifying.Retrieve("etc/passwd", 1); err == nil {
+		t.Fatal("Unsigned object was retrieved")
+	}
+
+	// Objects stored before the signature existed are unsigned as well
+	legacy, err := encryptionBackend.Encrypt(data, nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt legacy object: %v", err)
+	}
+	backend.Put("legacy", legacy)
+	verifying.AllowLegacy()
+	if _, err := verifying.Retrieve("legacy", 0); err == nil {
+		t.Fatal("Unsigned legacy object was retrieved")
+	}
+
+	// The retriever can't sign
+	if _, err := verifier.Forward(&Object{}, data); err == nil {
+		t.Fatal("Object was signed without a private key")
+	}
+}